@@ -0,0 +1,162 @@
+// Package analyzer provides the analysis.Analyzer which reports the functions which do not call the segment.
+// It runs with go vet, golangci-lint and gopls, and suggests the fix which inserts the segment.
+package analyzer
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/budougumi0617/nrseg"
+	"golang.org/x/tools/go/analysis"
+)
+
+const doc = `nrseg reports functions which have context.Context but do not call the segment
+
+It detects the same functions as "nrseg inspect -types", and suggests the fix which inserts the segment.
+The configuration is loaded from .nrseg.yaml in the directory of the file or its parent directories.`
+
+// Analyzer reports the functions which do not call the segment.
+var Analyzer = newAnalyzer()
+
+// analyzer has the flags of Analyzer.
+type analyzer struct {
+	configPath string
+	checkNames bool
+}
+
+func newAnalyzer() *analysis.Analyzer {
+	a := &analyzer{}
+	aa := &analysis.Analyzer{
+		Name: "nrseg",
+		Doc:  doc,
+		URL:  "https://github.com/budougumi0617/nrseg",
+		Run:  a.run,
+	}
+	aa.Flags.StringVar(&a.configPath, "config", "", "configuration file path. (default: "+nrseg.ConfigFileName+" in the directory of the file or its parent directories.)")
+	aa.Flags.BoolVar(&a.checkNames, "names", false, "report segments whose names do not match the naming style.")
+	return aa
+}
+
+// config returns the configuration for dir.
+// configs caches it in the pass, so the long-running driver such as gopls loads the edited configuration and files in the next pass.
+func (a *analyzer) config(pass *analysis.Pass, configs map[string]*nrseg.Config, dir string) (*nrseg.Config, error) {
+	if cfg, ok := configs[dir]; ok {
+		return cfg, nil
+	}
+	var cfg *nrseg.Config
+	var err error
+	if len(a.configPath) != 0 {
+		cfg, err = nrseg.LoadConfig(a.configPath)
+	} else {
+		cfg, err = nrseg.ConfigFor(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the parameters are detected with the type information of the pass.
+	// If context.Context is not imported by the package, the syntactic detection is used.
+	_ = cfg.LoadTypesInfo(pass.Fset, pass.Pkg, pass.Files, pass.TypesInfo)
+	configs[dir] = cfg
+	return cfg, nil
+}
+
+func (a *analyzer) run(pass *analysis.Pass) (interface{}, error) {
+	configs := map[string]*nrseg.Config{}
+	for _, f := range pass.Files {
+		fn := pass.Fset.File(f.Pos()).Name()
+		if strings.HasSuffix(fn, "_test.go") {
+			continue
+		}
+		cfg, err := a.config(pass, configs, filepath.Dir(fn))
+		if err != nil {
+			return nil, err
+		}
+		is, err := nrseg.Check(pass.Fset, f, cfg, a.checkNames)
+		if err != nil {
+			return nil, err
+		}
+		var src []byte
+		if len(is) != 0 && pass.ReadFile != nil {
+			// the source is used only for the indentation of the suggested fix.
+			src, _ = pass.ReadFile(fn)
+		}
+		for _, i := range is {
+			d := analysis.Diagnostic{
+				Pos:      i.Pos,
+				Category: i.Rule,
+				Message:  i.Label + " " + i.Message,
+			}
+			if fix, ok := suggestedFix(pass.Fset, f, src, i); ok {
+				d.SuggestedFixes = []analysis.SuggestedFix{fix}
+			}
+			pass.Report(d)
+		}
+	}
+	return nil, nil
+}
+
+// suggestedFix returns the fix which inserts i.Stmts before the first statement of the function.
+func suggestedFix(fs *token.FileSet, f *ast.File, src []byte, i nrseg.Issue) (analysis.SuggestedFix, bool) {
+	if len(i.Stmts) == 0 {
+		return analysis.SuggestedFix{}, false
+	}
+	first := i.Body.List[0].Pos()
+	indent := indentAt(fs, src, first)
+	var buf bytes.Buffer
+	for _, s := range i.Stmts {
+		if err := format.Node(&buf, fs, s); err != nil {
+			return analysis.SuggestedFix{}, false
+		}
+		buf.WriteString("\n" + indent)
+	}
+	edits := []analysis.TextEdit{{Pos: first, End: first, NewText: buf.Bytes()}}
+	if len(i.Import) != 0 {
+		edits = append(edits, importEdit(f, i.Import, i.ImportName))
+	}
+	return analysis.SuggestedFix{
+		Message:   "Insert the segment",
+		TextEdits: edits,
+	}, true
+}
+
+// indentAt returns the white spaces at the head of the line of pos.
+func indentAt(fs *token.FileSet, src []byte, pos token.Pos) string {
+	p := fs.Position(pos)
+	if p.Offset > len(src) || p.Column < 1 {
+		return "\t"
+	}
+	line := src[p.Offset-(p.Column-1) : p.Offset]
+	if len(bytes.TrimLeft(line, " \t")) != 0 {
+		return "\t"
+	}
+	return string(line)
+}
+
+// importEdit returns the edit which adds the import of path to f.
+func importEdit(f *ast.File, path, name string) analysis.TextEdit {
+	spec := strconv.Quote(path)
+	if len(name) != 0 {
+		spec = name + " " + spec
+	}
+	var last *ast.GenDecl
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			last = gd
+		}
+	}
+	switch {
+	case last == nil:
+		return analysis.TextEdit{Pos: f.Name.End(), End: f.Name.End(), NewText: []byte("\n\nimport " + spec)}
+	case last.Lparen.IsValid() && len(last.Specs) == 0:
+		return analysis.TextEdit{Pos: last.Lparen + 1, End: last.Lparen + 1, NewText: []byte("\n\t" + spec + "\n")}
+	case last.Lparen.IsValid():
+		end := last.Specs[len(last.Specs)-1].End()
+		return analysis.TextEdit{Pos: end, End: end, NewText: []byte("\n\t" + spec)}
+	}
+	return analysis.TextEdit{Pos: last.End(), End: last.End(), NewText: []byte("\nimport " + spec)}
+}
@@ -0,0 +1,28 @@
+package a
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type S struct{}
+
+func (s *S) SampleMethod(ctx context.Context) { // want "S.SampleMethod no insert segment"
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) { // want "SampleHandler no insert segment"
+	go func() { // want "SampleHandler.func1 no insert segment"
+		fmt.Println(req.URL.Path)
+	}()
+}
+
+// nrseg:ignore you can be ignored
+func Ignored(ctx context.Context) {
+	fmt.Println("ignored")
+}
+
+func NoContext() {
+	fmt.Println("no context")
+}
@@ -0,0 +1,7 @@
+package b
+
+import "context"
+
+func SampleFunc(ctx context.Context) { // want "SampleFunc no insert segment"
+	_ = ctx
+}
@@ -0,0 +1,13 @@
+package c
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ctx is detected only with the type information.
+type Ctx = context.Context
+
+func Alias(c Ctx) { // want "Alias no insert segment"
+	fmt.Println("type alias")
+}
@@ -0,0 +1,197 @@
+package nrseg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultBaselineFile is the conventional name of the baseline file.
+const DefaultBaselineFile = ".nrseg-baseline.json"
+
+const baselineVersion = 1
+
+// baseline is the findings which are accepted as the existing debt.
+type baseline struct {
+	Version  int             `json:"version"`
+	Findings []baselineEntry `json:"findings"`
+
+	path string
+}
+
+// baselineEntry is the finding in the baseline. It has no position so that it survives edits.
+type baselineEntry struct {
+	Rule     string `json:"rule"`
+	Package  string `json:"package"`
+	Receiver string `json:"receiver,omitempty"`
+	Function string `json:"function"`
+	Literal  string `json:"literal,omitempty"`
+}
+
+func newBaselineEntry(f Finding) baselineEntry {
+	return baselineEntry{
+		Rule:     f.Rule,
+		Package:  f.Package,
+		Receiver: f.Receiver,
+		Function: f.Function,
+		Literal:  f.Literal,
+	}
+}
+
+// function returns the entry without the rule, it is the key of the function.
+func (e baselineEntry) function() baselineEntry {
+	e.Rule = ""
+	return e
+}
+
+// packageEntry returns the key which means that all functions of the package are inspected.
+func packageEntry(pkg string) baselineEntry {
+	return baselineEntry{Package: pkg}
+}
+
+// inspectedFuncs returns the functions in f which are inspected as the keys of the baseline entries.
+// The functions which are not changed since the git ref are not inspected.
+func inspectedFuncs(fs *token.FileSet, f *ast.File, cfg *Config) []baselineEntry {
+	changed := cfg.changedFunc(fs, f)
+	var result []baselineEntry
+	for _, t := range findFuncs(fs, f, cfg) {
+		if t.nd == nil || !changed(t.body) {
+			continue
+		}
+		e := baselineEntry{Receiver: t.nd.Receiver, Function: t.nd.Function, Literal: t.nd.Literal}
+		e.Package = t.nd.ImportPath
+		if len(e.Package) == 0 {
+			e.Package = t.nd.Package
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// label returns the human readable name of the entry. ex: example.com/foo.S.Method
+func (e baselineEntry) label() string {
+	parts := []string{e.Package}
+	for _, p := range []string{e.Receiver, e.Function, e.Literal} {
+		if len(p) != 0 {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func newBaseline(fds []Finding) *baseline {
+	b := &baseline{Version: baselineVersion, Findings: []baselineEntry{}}
+	for _, f := range fds {
+		b.Findings = append(b.Findings, newBaselineEntry(f))
+	}
+	sort.Slice(b.Findings, func(i, j int) bool {
+		bi, bj := b.Findings[i], b.Findings[j]
+		if bi.Package != bj.Package {
+			return bi.Package < bj.Package
+		}
+		if bi.label() != bj.label() {
+			return bi.label() < bj.label()
+		}
+		return bi.Rule < bj.Rule
+	})
+	return b
+}
+
+func loadBaseline(path string) (*baseline, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b baseline
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("cannot load baseline %q: %w", path, err)
+	}
+	if b.Version != baselineVersion {
+		return nil, fmt.Errorf("unsupported baseline version %d in %q", b.Version, path)
+	}
+	b.path = path
+	return &b, nil
+}
+
+func writeBaseline(path string, fds []Finding) error {
+	bs, err := json.MarshalIndent(newBaseline(fds), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(bs, '\n'), 0644, "")
+}
+
+// filter returns the findings which are not in the baseline, and the entries which are not found anymore.
+// The entries are fixed only if their functions or their packages are in funcs, because the other functions are not inspected.
+// The entry of the function which is deleted or renamed is fixed if its package is in funcs.
+func (b *baseline) filter(fds []Finding, funcs map[baselineEntry]bool) ([]Finding, []baselineEntry) {
+	known := map[baselineEntry]int{}
+	for _, e := range b.Findings {
+		known[e]++
+	}
+	var news []Finding
+	for _, f := range fds {
+		e := newBaselineEntry(f)
+		if known[e] > 0 {
+			known[e]--
+			continue
+		}
+		news = append(news, f)
+	}
+	var fixed []baselineEntry
+	for _, e := range b.Findings {
+		if known[e] > 0 && (funcs[e.function()] || funcs[packageEntry(e.Package)]) {
+			known[e]--
+			fixed = append(fixed, e)
+		}
+	}
+	return news, fixed
+}
+
+func writeFixed(w io.Writer, path string, fixed []baselineEntry) {
+	if len(fixed) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d finding(s) in %s are fixed, remove them from the baseline:\n", len(fixed), path)
+	for _, e := range fixed {
+		fmt.Fprintf(w, "\t%s %s\n", e.label(), e.Rule)
+	}
+}
+
+// applyBaseline writes the findings to the baseline file, or drops the findings in the baseline.
+// err is the error of run, the baseline is not written from the partial findings.
+func (n *nrseg) applyBaseline(err error) error {
+	switch {
+	case len(n.writeBaseline) != 0:
+		if err != nil {
+			return nil
+		}
+		if err := writeBaseline(n.writeBaseline, n.findings); err != nil {
+			return err
+		}
+		fmt.Fprintf(n.errStream, "%d finding(s) are written to %s\n", len(n.findings), n.writeBaseline)
+		n.findings, n.errFlag = nil, false
+	case n.base != nil:
+		var fixed []baselineEntry
+		n.findings, fixed = n.base.filter(n.findings, n.inspected)
+		n.errFlag = len(n.findings) != 0
+		writeFixed(n.errStream, n.base.path, fixed)
+	}
+	return nil
+}
+
+// findingPackage returns the import path of the package, or its name if the import path is unknown.
+func (cfg *Config) findingPackage(path, name string) string {
+	if ip := cfg.importPath(path); len(ip) != 0 {
+		return ip
+	}
+	return name
+}
@@ -0,0 +1,176 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_baseline_filter(t *testing.T) {
+	t.Parallel()
+	entry := func(pkg, recv, fn string) baselineEntry {
+		return baselineEntry{Rule: RuleMissingSegment, Package: pkg, Receiver: recv, Function: fn}
+	}
+	finding := func(pkg, recv, fn string, line int) Finding {
+		return Finding{Rule: RuleMissingSegment, Package: pkg, Receiver: recv, Function: fn, Line: line}
+	}
+	b := &baseline{Version: baselineVersion, Findings: []baselineEntry{
+		entry("example.com/a", "S", "Method"),
+		entry("example.com/a", "", "init"),
+		entry("example.com/a", "", "init"),
+		entry("example.com/a", "", "Fixed"),
+		entry("example.com/a", "", "NotInspected"),
+		entry("example.com/b", "", "NotInspected"),
+		entry("example.com/c", "", "Deleted"),
+	}}
+	fds := []Finding{
+		// the line is changed but it is the same function.
+		finding("example.com/a", "S", "Method", 100),
+		finding("example.com/a", "", "init", 3),
+		finding("example.com/a", "", "init", 10),
+		finding("example.com/a", "", "init", 20),
+		finding("example.com/a", "T", "Method", 30),
+	}
+	funcs := map[baselineEntry]bool{}
+	for _, fn := range []string{"init", "Fixed"} {
+		funcs[entry("example.com/a", "", fn).function()] = true
+	}
+	funcs[entry("example.com/a", "S", "Method").function()] = true
+	// all functions of example.com/c are inspected, and Deleted is not found.
+	funcs[packageEntry("example.com/c")] = true
+	gotNew, gotFixed := b.filter(fds, funcs)
+	wantNew := []Finding{
+		finding("example.com/a", "", "init", 20),
+		finding("example.com/a", "T", "Method", 30),
+	}
+	if diff := cmp.Diff(gotNew, wantNew); len(diff) != 0 {
+		t.Errorf("new findings -got +want %v", diff)
+	}
+	wantFixed := []baselineEntry{entry("example.com/a", "", "Fixed"), entry("example.com/c", "", "Deleted")}
+	if diff := cmp.Diff(gotFixed, wantFixed); len(diff) != 0 {
+		t.Errorf("fixed entries -got +want %v", diff)
+	}
+}
+
+func TestNrseg_Run_Baseline(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	write := func(name, src string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/base\n\ngo 1.16\n")
+	write("a.go", `package base
+
+import "context"
+
+type S struct{}
+
+func (s *S) Method(ctx context.Context) { _ = ctx }
+
+func Fixed(ctx context.Context) { _ = ctx }
+`)
+	bp := filepath.Join(dir, DefaultBaselineFile)
+
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	if err := Run([]string{"nrseg", "inspect", "-write-baseline", bp, dir}, out, errs, "", ""); err != nil {
+		t.Fatalf("write baseline failed: %v: %s", err, errs)
+	}
+	if out.Len() != 0 {
+		t.Errorf("want no findings, but got %q", out)
+	}
+	got, err := os.ReadFile(bp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "version": 1,
+  "findings": [
+    {
+      "rule": "missing-segment",
+      "package": "example.com/base",
+      "function": "Fixed"
+    },
+    {
+      "rule": "missing-segment",
+      "package": "example.com/base",
+      "receiver": "S",
+      "function": "Method"
+    }
+  ]
+}
+`
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("baseline -got +want %v", diff)
+	}
+
+	// Method is moved, Fixed is fixed and New is added.
+	write("a.go", `package base
+
+import "context"
+
+type S struct{}
+
+func New(ctx context.Context) { _ = ctx }
+
+func (s *S) Method(ctx context.Context) { _ = ctx }
+
+func Fixed() { _ = 1 }
+`)
+	out.Reset()
+	errs.Reset()
+	if err := Run([]string{"nrseg", "inspect", "-baseline", bp, dir}, out, errs, "", ""); !errors.Is(err, ErrFlagTrue) {
+		t.Fatalf("want %v, but got %v: %s", ErrFlagTrue, err, errs)
+	}
+	if diff := cmp.Diff(out.String(), filepath.Join(dir, "a.go")+":7:1: New no insert segment\n"); len(diff) != 0 {
+		t.Errorf("findings -got +want %v", diff)
+	}
+	wantErrs := "1 finding(s) in " + bp + " are fixed, remove them from the baseline:\n" +
+		"\texample.com/base.Fixed missing-segment\n"
+	if diff := cmp.Diff(errs.String(), wantErrs); len(diff) != 0 {
+		t.Errorf("fixed -got +want %v", diff)
+	}
+
+	// the entries of the files which are not inspected are not fixed.
+	write("b.go", `package base
+
+import "context"
+
+func Other(ctx context.Context) { _ = ctx }
+`)
+	if err := Run([]string{"nrseg", "inspect", "-write-baseline", bp, dir}, out, errs, "", ""); err != nil {
+		t.Fatalf("write baseline failed: %v: %s", err, errs)
+	}
+	out.Reset()
+	errs.Reset()
+	if err := Run([]string{"nrseg", "inspect", "-baseline", bp, filepath.Join(dir, "a.go")}, out, errs, "", ""); err != nil {
+		t.Fatalf("inspect a file failed: %v: %s", err, errs)
+	}
+	if out.Len() != 0 || errs.Len() != 0 {
+		t.Errorf("want no output, but got %q, %q", out, errs)
+	}
+
+	// the entries of the deleted functions are fixed if their packages are inspected.
+	write("b.go", "package base\n")
+	if err := Run([]string{"nrseg", "inspect", "-baseline", bp, dir}, out, errs, "", ""); err != nil {
+		t.Fatalf("inspect the deleted function failed: %v: %s", err, errs)
+	}
+	wantErrs = "1 finding(s) in " + bp + " are fixed, remove them from the baseline:\n" +
+		"\texample.com/base.Other missing-segment\n"
+	if out.Len() != 0 || errs.String() != wantErrs {
+		t.Errorf("want no findings and %q, but got %q, %q", wantErrs, out, errs)
+	}
+
+	var ue *UsageError
+	args := []string{"nrseg", "inspect", "-baseline", filepath.Join(dir, "nosuch.json"), dir}
+	if err := Run(args, out, errs, "", ""); !errors.As(err, &ue) {
+		t.Errorf("want UsageError, but got %v", err)
+	}
+}
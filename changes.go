@@ -0,0 +1,183 @@
+package nrseg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lineRange is the changed lines from start to end. end is start if the lines are deleted after start.
+type lineRange struct {
+	start, end int
+}
+
+// changeSet has the changed lines of the files. The key is the absolute file path.
+// The ranges are nil if the file is new, then all lines are changed.
+type changeSet map[string][]lineRange
+
+// LoadChanges limits the targets to the functions changed since ref in the git repository of dir.
+// The changes are the diff between ref and the working tree, and the untracked files.
+// It uses only the local repository.
+func (cfg *Config) LoadChanges(dir, ref string) error {
+	root, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	top := strings.TrimSpace(string(root))
+	diff, err := git(top, "diff", "--no-color", "--no-ext-diff", "--unified=0", ref, "--", "*.go")
+	if err != nil {
+		return err
+	}
+	cs, err := parseDiff(top, bytes.NewReader(diff))
+	if err != nil {
+		return err
+	}
+	untracked, err := git(top, "ls-files", "--others", "--exclude-standard", "-z", "--", "*.go")
+	if err != nil {
+		return err
+	}
+	for _, p := range strings.Split(string(untracked), "\x00") {
+		if len(p) != 0 {
+			cs[filepath.Join(top, filepath.FromSlash(p))] = nil
+		}
+	}
+	cfg.changes = cs
+	return nil
+}
+
+// git runs the git command in dir, and returns the output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseDiff returns the changed lines in the unified diff. The paths in the diff are relative to root.
+func parseDiff(root string, r io.Reader) (changeSet, error) {
+	cs := changeSet{}
+	var cur string
+	// the lines of the hunk which are not read yet.
+	var oldLeft, newLeft int
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		l := sc.Text()
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(l, "-"):
+				oldLeft--
+			case strings.HasPrefix(l, "+"):
+				newLeft--
+			case strings.HasPrefix(l, " "):
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(l, "+++ "):
+			p := strings.TrimPrefix(l, "+++ ")
+			if strings.HasPrefix(p, `"`) {
+				up, err := strconv.Unquote(p)
+				if err != nil {
+					return nil, fmt.Errorf("cannot parse the path %s: %w", p, err)
+				}
+				p = up
+			}
+			if p == "/dev/null" {
+				cur = ""
+				continue
+			}
+			cur = filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(p, "b/")))
+			if _, ok := cs[cur]; !ok {
+				cs[cur] = []lineRange{}
+			}
+		case strings.HasPrefix(l, "@@ "):
+			m := hunkHeader.FindStringSubmatch(l)
+			if m == nil {
+				return nil, fmt.Errorf("cannot parse the hunk header %q", l)
+			}
+			oldLeft, newLeft = count(m[1]), count(m[3])
+			if len(cur) == 0 {
+				continue
+			}
+			start, _ := strconv.Atoi(m[2])
+			end := start
+			if newLeft > 0 {
+				end = start + newLeft - 1
+			}
+			cs[cur] = append(cs[cur], lineRange{start: start, end: end})
+		}
+	}
+	return cs, sc.Err()
+}
+
+// count returns the line count of the hunk header. It is 1 if it is omitted.
+func count(s string) int {
+	if len(s) == 0 {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// changedFile reports whether the file has any changes. It is true if the changes are not loaded.
+func (cfg *Config) changedFile(path string) bool {
+	if cfg == nil || cfg.changes == nil {
+		return true
+	}
+	_, ok := cfg.changes[realPath(path)]
+	return ok
+}
+
+// changedFunc returns the function which reports whether the lines of the node in f are changed.
+func (cfg *Config) changedFunc(fs *token.FileSet, f *ast.File) func(n ast.Node) bool {
+	all := func(ast.Node) bool { return true }
+	if cfg == nil || cfg.changes == nil {
+		return all
+	}
+	rs, ok := cfg.changes[realPath(fs.File(f.Pos()).Name())]
+	switch {
+	case !ok:
+		return func(ast.Node) bool { return false }
+	case rs == nil:
+		return all
+	}
+	return func(n ast.Node) bool {
+		start, end := fs.Position(n.Pos()).Line, fs.Position(n.End()).Line
+		for _, r := range rs {
+			if r.start <= end && start <= r.end {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// realPath returns the absolute path which has no symbolic links like the path given by git.
+func realPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if p, err := filepath.EvalSymlinks(abs); err == nil {
+		return p
+	}
+	return abs
+}
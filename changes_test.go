@@ -0,0 +1,203 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseDiff(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name string
+		diff string
+		want changeSet
+	}{
+		{
+			name: "hunks",
+			diff: `diff --git a/foo.go b/foo.go
+index 1111111..2222222 100644
+--- a/foo.go
++++ b/foo.go
+@@ -3,0 +4,2 @@ import "context"
++// added
++func Added() {}
+@@ -10 +12 @@ func Changed(ctx context.Context) {
+-	old()
++	changed()
+@@ -20,2 +21,0 @@ func Deleted(ctx context.Context) {
+-	deleted()
+-	deleted()
+`,
+			want: changeSet{"/repo/foo.go": {{start: 4, end: 5}, {start: 12, end: 12}, {start: 21, end: 21}}},
+		},
+		{
+			name: "content like header",
+			diff: `diff --git a/foo.go b/foo.go
+--- a/foo.go
++++ b/foo.go
+@@ -1,2 +1,2 @@
+--- a/bar.go
+-@@ -1 +1 @@
++++ b/bar.go
++@@ -1 +1 @@
+`,
+			want: changeSet{"/repo/foo.go": {{start: 1, end: 2}}},
+		},
+		{
+			name: "new, deleted and quoted files",
+			diff: `diff --git a/new.go b/new.go
+new file mode 100644
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1,3 @@
++package foo
++
++func New() {}
+diff --git a/old.go b/old.go
+deleted file mode 100644
+--- a/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package foo
+diff --git "a/sp ace.go" "b/sp ace.go"
+--- "a/sp ace.go"
++++ "b/sp ace.go"
+@@ -1 +1 @@
+-package foo
++package bar
+`,
+			want: changeSet{
+				"/repo/new.go":    {{start: 1, end: 3}},
+				"/repo/sp ace.go": {{start: 1, end: 1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseDiff("/repo", strings.NewReader(tt.diff))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want, cmp.AllowUnexported(lineRange{})); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestNrseg_Run_Since(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=nrseg", "-c", "user.email=nrseg@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, src string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/since\n\ngo 1.16\n")
+	write("changed.go", `package since
+
+import "context"
+
+func Changed(ctx context.Context) {
+	_ = ctx
+}
+
+func Unchanged(ctx context.Context) {
+	_ = ctx
+}
+`)
+	write("unchanged.go", `package since
+
+import "context"
+
+func Other(ctx context.Context) {
+	_ = ctx
+}
+`)
+	run("init", "-q")
+	run("add", "-A")
+	run("commit", "-q", "-m", "init")
+
+	write("changed.go", `package since
+
+import "context"
+
+func Changed(ctx context.Context) {
+	_ = ctx
+	_ = ctx.Err()
+}
+
+func Unchanged(ctx context.Context) {
+	_ = ctx
+}
+`)
+	write("new.go", `package since
+
+import "context"
+
+func New(ctx context.Context) {
+	_ = ctx
+}
+`)
+
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	args := []string{"nrseg", "inspect", "-since", "HEAD", dir}
+	if err := Run(args, out, errs, "", ""); !errors.Is(err, ErrFlagTrue) {
+		t.Fatalf("want %v, but got %v: %s", ErrFlagTrue, err, errs)
+	}
+	want := filepath.Join(dir, "changed.go") + ":5:1: Changed no insert segment\n" +
+		filepath.Join(dir, "new.go") + ":5:1: New no insert segment\n"
+	if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	out.Reset()
+	args = []string{"nrseg", "-l", "-since", "HEAD", dir}
+	if err := Run(args, out, errs, "", ""); !errors.Is(err, ErrFlagTrue) {
+		t.Fatalf("want %v, but got %v: %s", ErrFlagTrue, err, errs)
+	}
+	want = filepath.Join(dir, "changed.go") + "\n" + filepath.Join(dir, "new.go") + "\n"
+	if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	// the unchanged functions are not counted as skipped.
+	out.Reset()
+	args = []string{"nrseg", "stats", "-since", "HEAD", dir}
+	if err := Run(args, out, errs, "", ""); err != nil {
+		t.Fatalf("stats failed: %v: %s", err, errs)
+	}
+	want = "PACKAGE            ELIGIBLE  INSTRUMENTED  IGNORED  SKIPPED  COVERAGE\n" +
+		"example.com/since  2         0             0        0        0.0%\n" +
+		"total              2         0             0        0        0.0%\n"
+	if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+		t.Errorf("stats -got +want %v", diff)
+	}
+
+	args = []string{"nrseg", "inspect", "-since", "nosuch", dir}
+	var ue *UsageError
+	if err := Run(args, out, errs, "", ""); !errors.As(err, &ue) {
+		t.Errorf("want UsageError, but got %v", err)
+	}
+}
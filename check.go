@@ -0,0 +1,82 @@
+package nrseg
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// Issue is the finding of Check with the syntax nodes to fix it.
+type Issue struct {
+	Finding
+	// Pos is the position of the function.
+	Pos token.Pos
+	// Body is the body of the function. It is nil for RuleDirective.
+	Body *ast.BlockStmt
+	// Stmts is the statements which should be inserted at the top of Body.
+	// It is empty for RuleSegmentName, RuleDirective and RuleGoroutine.
+	Stmts []ast.Stmt
+	// Import is the import path which should be added to the file with ImportName.
+	// It is empty if the file imports it already.
+	Import, ImportName string
+}
+
+// Check returns the functions in f which have the context but do not call the segment.
+// If names is true, it returns the segments whose names do not match the naming style too.
+// The invalid nrseg: directives are returned as RuleDirective.
+// If cfg.NewGoroutine is true, the go statements which use the context without NewGoroutine are returned as RuleGoroutine.
+// cfg can be nil, then Check uses the default configuration.
+func Check(fs *token.FileSet, f *ast.File, cfg *Config, names bool) ([]Issue, error) {
+	if ast.IsGenerated(f) || !cfg.matchFile(fs.File(f.Pos()).Name()) {
+		return nil, nil
+	}
+	ins := cfg.instrumenter()
+	pkg := ins.PackageName()
+	var imp, impName string
+	name, err := findImport(f, ins.ImportPath())
+	switch {
+	case errors.Is(err, ErrNoImportNrPkg):
+		imp, impName = ins.ImportPath(), cfg.importAlias()
+		if len(impName) != 0 {
+			pkg = impName
+		}
+	case err != nil:
+		return nil, err
+	case len(name) != 0:
+		// change name if named import.
+		pkg = name
+	}
+
+	result := checkDirectives(fs, f, cfg)
+	for _, t := range findTargets(fs, f, cfg) {
+		if cfg.newGoroutine() {
+			result = append(result, goroutineIssues(fs, f, t)...)
+		}
+		if !ins.Instrumented(pkg, t.body.List[0]) {
+			result = append(result, Issue{
+				Finding:    newFinding(fs, t, RuleMissingSegment, "no insert segment"),
+				Pos:        t.node.Pos(),
+				Body:       t.body,
+				Stmts:      ins.Build(t.target(pkg)),
+				Import:     imp,
+				ImportName: impName,
+			})
+			continue
+		}
+		if !names {
+			continue
+		}
+		sn, ok := ins.(SegmentNamer)
+		if !ok {
+			continue
+		}
+		if n, ok := sn.SegmentName(pkg, t.body.List[0]); ok && n != t.name {
+			fd := newFinding(fs, t, RuleSegmentName, "segment name %q does not match %q", n, t.name)
+			fd.Current = n
+			result = append(result, Issue{Finding: fd, Pos: t.node.Pos(), Body: t.body})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Pos < result[j].Pos })
+	return result, nil
+}
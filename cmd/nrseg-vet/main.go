@@ -0,0 +1,11 @@
+// nrseg-vet reports functions which do not call the segment with go vet.
+//
+//	$ go vet -vettool=$(which nrseg-vet) ./...
+package main
+
+import (
+	"github.com/budougumi0617/nrseg/analyzer"
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() { unitchecker.Main(analyzer.Analyzer) }
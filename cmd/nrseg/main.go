@@ -12,7 +12,6 @@ var (
 )
 
 func main() {
-	if err := nrseg.Run(os.Args, os.Stdout, os.Stderr, Version, Revision); err != nil {
-		os.Exit(1)
-	}
+	err := nrseg.Run(os.Args, os.Stdout, os.Stderr, Version, Revision)
+	os.Exit(nrseg.ExitCode(err))
 }
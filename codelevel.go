@@ -0,0 +1,210 @@
+package nrseg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// The attributes of New Relic code-level metrics.
+const (
+	AttrCodeFunction  = "code.function"
+	AttrCodeNamespace = "code.namespace"
+	AttrCodeFilepath  = "code.filepath"
+	AttrCodeLineno    = "code.lineno"
+)
+
+// segmentVar is the variable name of the segment in the code-level metrics form.
+// If the function uses it already, segmentVarAlt, segmentVarAlt2... are used.
+const (
+	segmentVar    = "seg"
+	segmentVarAlt = "nrSeg"
+)
+
+// codeLevelAttrs returns the values of the code-level metrics attributes of t.
+func codeLevelAttrs(t *Target) [][2]ast.Expr {
+	pos := t.Pos
+	str := func(s string) ast.Expr {
+		return &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(s)}
+	}
+	fn, ns, fp := t.Function, t.ImportPath, t.File
+	if len(t.Literal) != 0 {
+		fn += "." + t.Literal
+	}
+	if len(ns) == 0 {
+		ns = t.Package
+	} else {
+		fp = t.modulePath
+	}
+	if len(t.Receiver) != 0 {
+		ns += "." + t.Receiver
+	}
+	return [][2]ast.Expr{
+		{str(AttrCodeFunction), str(fn)},
+		{str(AttrCodeNamespace), str(ns)},
+		{str(AttrCodeFilepath), str(fp)},
+		{str(AttrCodeLineno), &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: strconv.Itoa(t.Line)}},
+	}
+}
+
+// buildCodeLevelStmts builds the statements which start the segment, add the code-level metrics attributes and end it.
+// start is the call expression which returns the segment.
+//
+//	seg := newrelic.FromContext(ctx).StartSegment("sample_func")
+//	seg.AddAttribute("code.function", "SampleFunc")
+//	seg.AddAttribute("code.namespace", "github.com/foo/bar")
+//	seg.AddAttribute("code.filepath", "sample.go")
+//	seg.AddAttribute("code.lineno", 10)
+//	defer seg.End()
+func buildCodeLevelStmts(t *Target, start ast.Expr) []ast.Stmt {
+	pos := t.Pos
+	id := func(name string) *ast.Ident {
+		return &ast.Ident{NamePos: pos, Name: name}
+	}
+	v := t.FreeName(segmentVar, segmentVarAlt)
+	ss := []ast.Stmt{&ast.AssignStmt{
+		Lhs:    []ast.Expr{id(v)},
+		TokPos: pos,
+		Tok:    token.DEFINE,
+		Rhs:    []ast.Expr{start},
+	}}
+	for _, a := range codeLevelAttrs(t) {
+		ss = append(ss, &ast.ExprStmt{X: &ast.CallExpr{
+			Fun:    &ast.SelectorExpr{X: id(v), Sel: id("AddAttribute")},
+			Lparen: pos,
+			Args:   []ast.Expr{a[0], a[1]},
+			Rparen: pos,
+		}})
+	}
+	return append(ss, &ast.DeferStmt{
+		Defer: pos,
+		Call: &ast.CallExpr{
+			Fun:    &ast.SelectorExpr{X: id(v), Sel: id("End")},
+			Lparen: pos,
+			Rparen: pos,
+		},
+	})
+}
+
+// segmentVarStmts returns the number of the statements from the head of ss which have the form built by buildCodeLevelStmts.
+// The variable of the segment can have any name, such as nrSeg used when seg is declared in the function.
+// start reports whether the call expression assigned to the variable starts the segment.
+// If all is false, only the code-level metrics attributes are accepted.
+func segmentVarStmts(ss []ast.Stmt, start func(*ast.CallExpr) bool, all bool) int {
+	as, ok := ss[0].(*ast.AssignStmt)
+	if !ok || as.Tok != token.DEFINE || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+		return 0
+	}
+	v, ok := as.Lhs[0].(*ast.Ident)
+	if !ok {
+		return 0
+	}
+	if ce, ok := as.Rhs[0].(*ast.CallExpr); !ok || !start(ce) {
+		return 0
+	}
+	for i := 1; i < len(ss); i++ {
+		switch s := ss[i].(type) {
+		case *ast.ExprStmt:
+			ce, ok := s.X.(*ast.CallExpr)
+			if !ok || !isMethodOf(ce, v.Name, "AddAttribute") || len(ce.Args) != 2 {
+				return 0
+			}
+			if !all && !isCodeLevelAttr(ce.Args[0]) {
+				return 0
+			}
+		case *ast.DeferStmt:
+			if !isMethodOf(s.Call, v.Name, "End") || len(s.Call.Args) != 0 {
+				return 0
+			}
+			return i + 1
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// isMethodOf reports whether ce calls the method of the variable. ex: seg.End()
+func isMethodOf(ce *ast.CallExpr, v, method string) bool {
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != method {
+		return false
+	}
+	idt, ok := se.X.(*ast.Ident)
+	return ok && idt.Name == v
+}
+
+func isCodeLevelAttr(e ast.Expr) bool {
+	bl, ok := e.(*ast.BasicLit)
+	if !ok || bl.Kind != token.STRING {
+		return false
+	}
+	k, err := strconv.Unquote(bl.Value)
+	return err == nil && strings.HasPrefix(k, "code.")
+}
+
+// fixLinenos rewrites the code.lineno attributes in src to the lines of the functions.
+// Only the integer literals are rewritten, the other code is kept as it is.
+func fixLinenos(filename string, src []byte) ([]byte, error) {
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var bls []*ast.BasicLit
+	var lines []int
+	ast.Inspect(f, func(n ast.Node) bool {
+		var body *ast.BlockStmt
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		}
+		if body == nil || len(body.List) == 0 {
+			return true
+		}
+		g := segmentVarStmts(body.List, isStartSegment, false)
+		for _, s := range body.List[:g] {
+			if bl := linenoLit(s); bl != nil {
+				bls = append(bls, bl)
+				lines = append(lines, fs.Position(n.Pos()).Line)
+			}
+		}
+		return true
+	})
+
+	var buf bytes.Buffer
+	var last int
+	for i, bl := range bls {
+		start, end := fs.Position(bl.Pos()).Offset, fs.Position(bl.End()).Offset
+		buf.Write(src[last:start])
+		buf.WriteString(strconv.Itoa(lines[i]))
+		last = end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
+
+// linenoLit returns the integer literal of code.lineno if s adds it.
+func linenoLit(s ast.Stmt) *ast.BasicLit {
+	es, ok := s.(*ast.ExprStmt)
+	if !ok {
+		return nil
+	}
+	ce, ok := es.X.(*ast.CallExpr)
+	if !ok || len(ce.Args) != 2 {
+		return nil
+	}
+	if k, ok := ce.Args[0].(*ast.BasicLit); !ok || k.Value != strconv.Quote(AttrCodeLineno) {
+		return nil
+	}
+	bl, ok := ce.Args[1].(*ast.BasicLit)
+	if !ok || bl.Kind != token.INT {
+		return nil
+	}
+	return bl
+}
@@ -0,0 +1,269 @@
+package nrseg
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const codeLevelSrc = `package sample
+
+import (
+	"context"
+	"net/http"
+)
+
+type S struct{}
+
+func (s *S) Method(ctx context.Context) {
+	_ = ctx
+	go func() {
+		_ = ctx
+	}()
+}
+
+func Handler(w http.ResponseWriter, req *http.Request) {
+	_ = req
+}
+`
+
+const codeLevelWant = `package sample
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type S struct{}
+
+func (s *S) Method(ctx context.Context) {
+	seg := newrelic.FromContext(ctx).StartSegment("s_method")
+	seg.AddAttribute("code.function", "Method")
+	seg.AddAttribute("code.namespace", "github.com/budougumi0617/nrseg.S")
+	seg.AddAttribute("code.filepath", "sample.go")
+	seg.AddAttribute("code.lineno", 12)
+	defer seg.End()
+	_ = ctx
+	go func() {
+		seg := newrelic.FromContext(ctx).StartSegment("s_method_func1")
+		seg.AddAttribute("code.function", "Method.func1")
+		seg.AddAttribute("code.namespace", "github.com/budougumi0617/nrseg.S")
+		seg.AddAttribute("code.filepath", "sample.go")
+		seg.AddAttribute("code.lineno", 20)
+		defer seg.End()
+		_ = ctx
+	}()
+}
+
+func Handler(w http.ResponseWriter, req *http.Request) {
+	seg := newrelic.FromContext(req.Context()).StartSegment("handler")
+	seg.AddAttribute("code.function", "Handler")
+	seg.AddAttribute("code.namespace", "github.com/budougumi0617/nrseg")
+	seg.AddAttribute("code.filepath", "sample.go")
+	seg.AddAttribute("code.lineno", 31)
+	defer seg.End()
+	_ = req
+}
+`
+
+func TestProcessWithConfig_CodeLevelMetrics(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{CodeLevelMetrics: true}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ProcessWithConfig("sample.go", []byte(codeLevelSrc), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), codeLevelWant); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+	// the instrumented functions are kept.
+	got, err = ProcessWithConfig("sample.go", got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), codeLevelWant); len(diff) != 0 {
+		t.Errorf("second run -got +want %v", diff)
+	}
+}
+
+func TestProcessWithConfig_CodeLevelMetrics_Collision(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{CodeLevelMetrics: true}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	src := `package sample
+
+import "context"
+
+func Declared(ctx context.Context) {
+	seg := 1
+	_ = seg
+}
+
+func Param(ctx context.Context, seg, nrSeg int) {
+	_ = seg + nrSeg
+}
+`
+	want := `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Declared(ctx context.Context) {
+	nrSeg := newrelic.FromContext(ctx).StartSegment("declared")
+	nrSeg.AddAttribute("code.function", "Declared")
+	nrSeg.AddAttribute("code.namespace", "github.com/budougumi0617/nrseg")
+	nrSeg.AddAttribute("code.filepath", "sample.go")
+	nrSeg.AddAttribute("code.lineno", 9)
+	defer nrSeg.End()
+	seg := 1
+	_ = seg
+}
+
+func Param(ctx context.Context, seg, nrSeg int) {
+	nrSeg2 := newrelic.FromContext(ctx).StartSegment("param")
+	nrSeg2.AddAttribute("code.function", "Param")
+	nrSeg2.AddAttribute("code.namespace", "github.com/budougumi0617/nrseg")
+	nrSeg2.AddAttribute("code.filepath", "sample.go")
+	nrSeg2.AddAttribute("code.lineno", 20)
+	defer nrSeg2.End()
+	_ = seg + nrSeg
+}
+`
+	got, err := ProcessWithConfig("sample.go", []byte(src), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+	// the renamed variables are detected by the second run and remove.
+	got, err = ProcessWithConfig("sample.go", got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("second run -got +want %v", diff)
+	}
+	got, err = RemoveWithConfig("sample.go", got, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := strings.Replace(src, `import "context"`, "import (\n\t\"context\"\n)", 1)
+	if diff := cmp.Diff(string(got), removed); len(diff) != 0 {
+		t.Errorf("remove -got +want %v", diff)
+	}
+}
+
+func TestCheck_CodeLevelMetrics(t *testing.T) {
+	t.Parallel()
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, "sample.go", codeLevelWant, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := Check(fs, f, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(is) != 0 {
+		t.Errorf("Check() = %v, want no issues", is)
+	}
+}
+
+func TestRemoveWithConfig_CodeLevelMetrics(t *testing.T) {
+	t.Parallel()
+	src := `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Generated(ctx context.Context) {
+	seg := newrelic.FromContext(ctx).StartSegment("generated")
+	seg.AddAttribute("code.function", "Generated")
+	seg.AddAttribute("code.lineno", 9)
+	defer seg.End()
+	_ = ctx
+}
+
+func Custom(ctx context.Context) {
+	txn := newrelic.FromContext(ctx)
+	seg := txn.StartSegment("custom")
+	seg.AddAttribute("user", "gopher")
+	defer seg.End()
+}
+`
+	tests := [...]struct {
+		name string
+		all  bool
+		want string
+	}{
+		{
+			name: "generated",
+			want: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Generated(ctx context.Context) {
+	_ = ctx
+}
+
+func Custom(ctx context.Context) {
+	txn := newrelic.FromContext(ctx)
+	seg := txn.StartSegment("custom")
+	seg.AddAttribute("user", "gopher")
+	defer seg.End()
+}
+`,
+		},
+		{
+			name: "all",
+			all:  true,
+			want: `package sample
+
+import (
+	"context"
+)
+
+func Generated(ctx context.Context) {
+	_ = ctx
+}
+
+func Custom(ctx context.Context) {
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := RemoveWithConfig("sample.go", []byte(src), nil, tt.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package nrseg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileName is the name of the configuration file.
+// nrseg searches it from the target directory to the root directory.
+const ConfigFileName = ".nrseg.yaml"
+
+// Config is the project configuration which is shared by all subcommands.
+type Config struct {
+	// Ignore is the directory names which are not processed.
+	Ignore []string `yaml:"ignore"`
+	// Include is the file globs which are processed. All files are processed if it is empty.
+	// The glob which has no slash matches the file name, others match the relative path from the configuration file.
+	Include []string `yaml:"include"`
+	// Exclude is the file globs which are not processed.
+	Exclude []string `yaml:"exclude"`
+	// SkipFuncs is the regular expressions of the function name to skip. ex: "^S\.Method$", "^New"
+	SkipFuncs []string `yaml:"skip_funcs"`
+	// Naming is the style of the segment name.
+	// It is the built-in style(snake, camel, dotted, slash) or the Go template with NameData.
+	Naming string `yaml:"naming"`
+	// ImportAlias is the package name used when nrseg adds the import of the instrumentation package.
+	ImportAlias string `yaml:"import_alias"`
+	// Backend is the instrumentation backend. newrelic(default) or otel.
+	Backend string `yaml:"backend"`
+	// Template is the statement template inserted instead of the backend. See TemplateData.
+	// ex: defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()
+	Template string `yaml:"template"`
+	// TemplateImport is the import path of the package used in Template.
+	TemplateImport string `yaml:"template_import"`
+	// CodeLevelMetrics adds the code-level metrics attributes to the New Relic segments.
+	CodeLevelMetrics bool `yaml:"code_level_metrics"`
+	// NoticeErrors notices the error result of the functions to the New Relic transaction.
+	// The function can opt in by nrseg:notice-error directive without it.
+	NoticeErrors bool `yaml:"notice_errors"`
+	// NewGoroutine passes the context which has the new goroutine of the transaction to the go statements.
+	NewGoroutine bool `yaml:"new_goroutine"`
+	// ContextTypes is the framework types which provide context.Context in addition to the built-in types.
+	ContextTypes []ContextType `yaml:"context_types"`
+	// Types enables the detection of the parameters with the type information. See LoadTypes.
+	Types bool `yaml:"types"`
+	// Instrumenter is the custom implementation used instead of Backend and Template.
+	Instrumenter Instrumenter `yaml:"-"`
+
+	dir       string
+	skipFuncs []*regexp.Regexp
+	namer     *template.Template
+	ins       Instrumenter
+	typed     typeIndex
+	changes   changeSet
+	ctxTypes  []*contextType
+	cache     *fileCache
+}
+
+// fileCache caches the lookups of the files around the processed files.
+// It belongs to the configuration, so a long-running driver gets the fresh results with a new configuration.
+type fileCache struct {
+	// modules is keyed by the directory.
+	modules sync.Map
+	// ignoredPackages is keyed by the directory and the package name.
+	ignoredPackages sync.Map
+}
+
+// fsCache returns nil if cfg is nil or not compiled, then the lookups are not cached.
+func (cfg *Config) fsCache() *fileCache {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.cache
+}
+
+// LoadConfig reads the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := &Config{}
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("cannot load %q: %w", path, err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.dir = filepath.Dir(abs)
+	if err := cfg.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %q: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ConfigFor returns the configuration applied to dir.
+// It loads the nearest configuration file from dir up to the module root, or returns the default configuration if there is none.
+func ConfigFor(dir string) (*Config, error) {
+	p, err := findConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		cfg := &Config{}
+		return cfg, cfg.Compile()
+	}
+	return LoadConfig(p)
+}
+
+// findConfig returns the path of the nearest configuration file from dir.
+// The search stops at the root of the module or the repository, the directory which has go.mod or .git.
+// It returns empty string if there is no configuration file.
+func findConfig(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(abs); err == nil && !fi.IsDir() {
+		abs = filepath.Dir(abs)
+	}
+	for {
+		p := filepath.Join(abs, ConfigFileName)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+		if isRootDir(abs) {
+			return "", nil
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", nil
+		}
+		abs = parent
+	}
+}
+
+// isRootDir reports whether dir is the root of the module or the repository.
+func isRootDir(dir string) bool {
+	for _, n := range []string{"go.mod", ".git"} {
+		if _, err := os.Stat(filepath.Join(dir, n)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile validates cfg and prepares it for processing.
+// LoadConfig calls it, so it is needed only when the fields are changed.
+func (cfg *Config) Compile() error {
+	namer, err := parseNaming(cfg.Naming)
+	if err != nil {
+		return err
+	}
+	cfg.namer = namer
+	cfg.cache = &fileCache{}
+	switch {
+	case (len(cfg.Template) != 0 || len(cfg.TemplateImport) != 0) && cfg.CodeLevelMetrics:
+		err = errCodeLevelMetrics
+	case len(cfg.Template) != 0 || len(cfg.TemplateImport) != 0:
+		cfg.ins, err = NewTemplateInstrumenter(cfg.Template, cfg.TemplateImport)
+	default:
+		cfg.ins, err = newInstrumenter(cfg.Backend, cfg.CodeLevelMetrics)
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.ins.(newrelicInstrumenter); !ok {
+		switch {
+		case cfg.NoticeErrors:
+			return errNoticeErrors
+		case cfg.NewGoroutine:
+			return errNewGoroutine
+		}
+	}
+	if cfg.ctxTypes, err = compileContextTypes(cfg.ContextTypes); err != nil {
+		return err
+	}
+	for _, g := range append(cfg.Include, cfg.Exclude...) {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return fmt.Errorf("bad glob %q: %w", g, err)
+		}
+	}
+	cfg.skipFuncs = nil
+	for _, s := range cfg.SkipFuncs {
+		r, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		cfg.skipFuncs = append(cfg.skipFuncs, r)
+	}
+	return nil
+}
+
+// matchFile reports whether path should be processed by include/exclude globs.
+func (cfg *Config) matchFile(path string) bool {
+	if cfg == nil {
+		return true
+	}
+	if len(cfg.Include) != 0 && !cfg.matchGlobs(cfg.Include, path) {
+		return false
+	}
+	return !cfg.matchGlobs(cfg.Exclude, path)
+}
+
+func (cfg *Config) matchGlobs(globs []string, path string) bool {
+	rel := filepath.Base(path)
+	if abs, err := filepath.Abs(path); err == nil && len(cfg.dir) != 0 {
+		if r, err := filepath.Rel(cfg.dir, abs); err == nil {
+			rel = r
+		}
+	}
+	rel = filepath.ToSlash(rel)
+	for _, g := range globs {
+		target := rel
+		if !strings.Contains(g, "/") {
+			target = filepath.Base(path)
+		}
+		if ok, _ := filepath.Match(g, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *Config) skipFunc(label string) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, r := range cfg.skipFuncs {
+		if r.MatchString(label) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *Config) noticeErrors() bool {
+	return cfg != nil && cfg.NoticeErrors
+}
+
+// newGoroutine reports whether the go statements are rewritten with NewGoroutine.
+func (cfg *Config) newGoroutine() bool {
+	if cfg == nil || !cfg.NewGoroutine {
+		return false
+	}
+	_, ok := cfg.instrumenter().(newrelicInstrumenter)
+	return ok
+}
+
+func (cfg *Config) importAlias() string {
+	if cfg == nil {
+		return ""
+	}
+	return cfg.ImportAlias
+}
+
+func (cfg *Config) instrumenter() Instrumenter {
+	switch {
+	case cfg == nil:
+		return newrelicInstrumenter{}
+	case cfg.Instrumenter != nil:
+		return cfg.Instrumenter
+	case cfg.ins != nil:
+		return cfg.ins
+	}
+	return newrelicInstrumenter{}
+}
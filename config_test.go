@@ -0,0 +1,252 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, src string
+		want      *Config
+		wantErr   bool
+	}{
+		{
+			name: "Full",
+			src: `ignore: [mock]
+include: ["*.go"]
+exclude: ["*_gen.go"]
+skip_funcs: ["^New"]
+naming: camel
+import_alias: nr
+backend: otel
+`,
+			want: &Config{
+				Ignore:      []string{"mock"},
+				Include:     []string{"*.go"},
+				Exclude:     []string{"*_gen.go"},
+				SkipFuncs:   []string{"^New"},
+				Naming:      NamingCamel,
+				ImportAlias: "nr",
+				Backend:     BackendOpenTelemetry,
+			},
+		},
+		{name: "Empty", src: "", want: &Config{}},
+		{name: "UnknownField", src: "ignores: [mock]\n", wantErr: true},
+		{name: "UnknownNaming", src: "naming: kebab\n", wantErr: true},
+		{name: "BadTemplate", src: "naming: \"{{.Pkg}}\"\n", wantErr: true},
+		{name: "UnknownBackend", src: "backend: zipkin\n", wantErr: true},
+		{name: "BadRegexp", src: "skip_funcs: [\"(\"]\n", wantErr: true},
+		{name: "BadGlob", src: "exclude: [\"[\"]\n", wantErr: true},
+		{name: "NoContextTypePath", src: "context_types: [{type: Ctx, context: \"{{.}}.Ctx()\"}]\n", wantErr: true},
+		{name: "BadContextType", src: "context_types: [{import_path: example.com/web, type: Ctx, context: \"{{.Var}}\"}]\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := filepath.Join(t.TempDir(), ConfigFileName)
+			if err := os.WriteFile(p, []byte(tt.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadConfig(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			opt := cmp.Comparer(func(x, y *Config) bool {
+				return cmp.Equal(x.Ignore, y.Ignore) && cmp.Equal(x.Include, y.Include) &&
+					cmp.Equal(x.Exclude, y.Exclude) && cmp.Equal(x.SkipFuncs, y.SkipFuncs) &&
+					x.Naming == y.Naming && x.ImportAlias == y.ImportAlias && x.Backend == y.Backend
+			})
+			if diff := cmp.Diff(got, tt.want, opt); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func Test_fill_FlagsOverrideConfig(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	src := `ignore: [mock]
+naming: camel
+template: "defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()"
+template_import: example.com/trace
+types: true
+`
+	p := filepath.Join(dir, ConfigFileName)
+	if err := os.WriteFile(p, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := [...]struct {
+		name string
+		args []string
+		want Config
+	}{
+		{
+			name: "NotSet",
+			args: []string{"-template", "", "-template-import", ""},
+			want: Config{Ignore: []string{"mock"}, Naming: NamingCamel, Types: true},
+		},
+		{
+			name: "Backend",
+			args: []string{"-backend", BackendNewRelic, "-types=false", "-ignore", "", "-naming", "snake"},
+			want: Config{Naming: NamingSnake, Backend: BackendNewRelic},
+		},
+		{
+			name: "BackendAndTemplate",
+			args: []string{"-backend", BackendNewRelic, "-template", "defer trace.Start({{.Ctx}})()", "-template-import", "example.com/trace", "-types=false"},
+			want: Config{Ignore: []string{"mock"}, Naming: NamingCamel, Backend: BackendNewRelic, Template: "defer trace.Start({{.Ctx}})()", TemplateImport: "example.com/trace"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			args := append([]string{"nrseg", "inspect", "-config", p}, append(tt.args, dir)...)
+			n, err := fill(args, &bytes.Buffer{}, &bytes.Buffer{}, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			opt := cmp.Comparer(func(x, y Config) bool {
+				return cmp.Equal(x.Ignore, y.Ignore) && x.Naming == y.Naming && x.Backend == y.Backend &&
+					x.Template == y.Template && x.TemplateImport == y.TemplateImport && x.Types == y.Types
+			})
+			if diff := cmp.Diff(*n.cfg, tt.want, opt); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func Test_fill_WarnConfigs(t *testing.T) {
+	t.Parallel()
+	a, b := configDir(t), t.TempDir()
+	if err := os.WriteFile(filepath.Join(b, ConfigFileName), []byte("naming: camel\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs := &bytes.Buffer{}
+	if _, err := fill([]string{"nrseg", "inspect", a, b}, &bytes.Buffer{}, errs, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "the configuration of " + b + " is ignored, " + filepath.Join(a, ConfigFileName) + " of the first path is used\n"
+	if got := errs.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	// -config is used for all paths explicitly.
+	errs.Reset()
+	if _, err := fill([]string{"nrseg", "inspect", "-config", filepath.Join(b, ConfigFileName), a, b}, &bytes.Buffer{}, errs, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if errs.Len() != 0 {
+		t.Errorf("want no warnings, but got %q", errs.String())
+	}
+}
+
+// configDir returns the temporary directory which has the empty configuration file.
+// The configuration files in the parent directories of the temporary directory are not used by the tests.
+func configDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFileName), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func Test_findConfig(t *testing.T) {
+	want, err := filepath.Abs("./testdata/config/" + ConfigFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"./testdata/config", "./testdata/config/mock", "./testdata/config/sample.go"} {
+		got, err := findConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("findConfig(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func Test_findConfig_ModuleRoot(t *testing.T) {
+	t.Parallel()
+	parent := configDir(t)
+	for _, root := range []string{"go.mod", ".git"} {
+		dir := filepath.Join(parent, root+"_root", "pkg")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		// .git is the file in the worktree of git.
+		if err := os.WriteFile(filepath.Join(filepath.Dir(dir), root), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := findConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("findConfig(%q) = %q, but the search must stop at %s", dir, got, root)
+		}
+	}
+}
+
+func TestConfig_matchFile(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{
+		Include: []string{"*.go"},
+		Exclude: []string{"*_gen.go", "internal/mock/*.go"},
+		dir:     "/src",
+	}
+	tests := [...]struct {
+		path string
+		want bool
+	}{
+		{path: "/src/main.go", want: true},
+		{path: "/src/foo/main_gen.go", want: false},
+		{path: "/src/internal/mock/mock.go", want: false},
+		{path: "/src/internal/mock.go", want: true},
+		{path: "/src/README.md", want: false},
+	}
+	for _, tt := range tests {
+		if got := cfg.matchFile(tt.path); got != tt.want {
+			t.Errorf("matchFile(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_Compile_NewRelicOnly(t *testing.T) {
+	t.Parallel()
+	tmpl := "defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()"
+	tests := [...]struct {
+		name string
+		cfg  *Config
+		want error
+	}{
+		{name: "CodeLevelMetricsOtel", cfg: &Config{Backend: BackendOpenTelemetry, CodeLevelMetrics: true}, want: errCodeLevelMetrics},
+		{name: "CodeLevelMetricsTemplate", cfg: &Config{Template: tmpl, TemplateImport: "example.com/trace", CodeLevelMetrics: true}, want: errCodeLevelMetrics},
+		{name: "NoticeErrorsOtel", cfg: &Config{Backend: BackendOpenTelemetry, NoticeErrors: true}, want: errNoticeErrors},
+		{name: "NoticeErrorsTemplate", cfg: &Config{Template: tmpl, TemplateImport: "example.com/trace", NoticeErrors: true}, want: errNoticeErrors},
+		{name: "NewGoroutineOtel", cfg: &Config{Backend: BackendOpenTelemetry, NewGoroutine: true}, want: errNewGoroutine},
+		{name: "NewGoroutineTemplate", cfg: &Config{Template: tmpl, TemplateImport: "example.com/trace", NewGoroutine: true}, want: errNewGoroutine},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if err := tt.cfg.Compile(); !errors.Is(err, tt.want) {
+				t.Errorf("Compile() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
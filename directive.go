@@ -0,0 +1,412 @@
+package nrseg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Directives written in the comments. ex: // nrseg:name custom_name
+const (
+	// directiveIgnore skips the function. The arguments are the reason.
+	directiveIgnore = "ignore"
+	// directiveName pins the segment name.
+	directiveName = "name"
+	// directiveCtx chooses the parameter which has the context.
+	directiveCtx = "ctx"
+	// directiveForce instruments the function which is skipped by skip_funcs or is deferred.
+	// With directiveCtx, the parameter is used as context.Context even if its type is unknown.
+	directiveForce = "force"
+	// directiveNoticeError notices the error result of the function. See Config.NoticeErrors.
+	directiveNoticeError = "notice-error"
+	// directiveIgnoreFile skips the file. It is written before the package clause.
+	directiveIgnoreFile = "ignore-file"
+	// directiveIgnorePackage skips all files of the package. It is written before the package clause of any file.
+	directiveIgnorePackage = "ignore-package"
+)
+
+var knownDirectives = []string{
+	directiveIgnore, directiveName, directiveCtx, directiveForce, directiveNoticeError, directiveIgnoreFile, directiveIgnorePackage,
+}
+
+// directiveReg matches the directive. "NRSEG:" matches too to report it.
+var directiveReg = regexp.MustCompile(`^//\s*((?i:nrseg)):(\S*)(.*)$`)
+
+// directive is the comment which starts with nrseg:.
+type directive struct {
+	pos    token.Pos
+	prefix string
+	name   string
+	args   []string
+}
+
+func parseDirective(c *ast.Comment) (directive, bool) {
+	m := directiveReg.FindStringSubmatch(c.Text)
+	if m == nil {
+		return directive{}, false
+	}
+	return directive{pos: c.Pos(), prefix: m[1], name: m[2], args: strings.Fields(m[3])}, true
+}
+
+func parseDirectives(cgs ...*ast.CommentGroup) []directive {
+	var ds []directive
+	for _, cg := range cgs {
+		if cg == nil {
+			continue
+		}
+		for _, c := range cg.List {
+			if d, ok := parseDirective(c); ok {
+				ds = append(ds, d)
+			}
+		}
+	}
+	return ds
+}
+
+// funcDirectives is the directives of the function.
+// The invalid directives are ignored, they are reported by checkDirectives.
+type funcDirectives struct {
+	ignore bool
+	name   string
+	ctx    string
+	force  bool
+	notice bool
+}
+
+func newFuncDirectives(ds []directive) funcDirectives {
+	var fd funcDirectives
+	for _, d := range ds {
+		if d.prefix != "nrseg" {
+			continue
+		}
+		switch d.name {
+		case directiveIgnore:
+			fd.ignore = true
+		case directiveName:
+			if len(d.args) == 1 {
+				fd.name = d.args[0]
+			}
+		case directiveCtx:
+			if len(d.args) == 1 {
+				fd.ctx = d.args[0]
+			}
+		case directiveForce:
+			fd.force = true
+		case directiveNoticeError:
+			fd.notice = true
+		}
+	}
+	return fd
+}
+
+// funcComments returns the comments of the function.
+// The function literal does not have any doc comment, so the comments on the line of it or the previous line are used.
+func funcComments(fs *token.FileSet, f *ast.File, n ast.Node) []*ast.CommentGroup {
+	if fd, ok := n.(*ast.FuncDecl); ok {
+		return []*ast.CommentGroup{fd.Doc}
+	}
+	l := fs.Position(n.Pos()).Line
+	var cgs []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		if el := fs.Position(cg.End()).Line; el == l || el == l-1 {
+			cgs = append(cgs, cg)
+		}
+	}
+	return cgs
+}
+
+func directivesOf(fs *token.FileSet, f *ast.File, n ast.Node) funcDirectives {
+	return newFuncDirectives(parseDirectives(funcComments(fs, f, n)...))
+}
+
+// directiveParams returns the function which uses the parameter chosen by nrseg:ctx.
+func (cfg *Config) directiveParams(fs *token.FileSet, f *ast.File, params paramsFunc) paramsFunc {
+	syntactic := cfg.syntacticParams(f)
+	return func(n ast.Node, ft *ast.FuncType) (string, string) {
+		fd := directivesOf(fs, f, n)
+		if len(fd.ctx) == 0 {
+			return params(n, ft)
+		}
+		return fd.ctx, ctxParamType(syntactic, n, ft, fd)
+	}
+}
+
+// ctxParamType returns the type of the parameter chosen by nrseg:ctx.
+func ctxParamType(params paramsFunc, n ast.Node, ft *ast.FuncType, fd funcDirectives) string {
+	field := findParam(ft, fd.ctx)
+	if field == nil {
+		return TypeUnknown
+	}
+	single := &ast.FuncType{Params: &ast.FieldList{List: []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent(fd.ctx)}, Type: field.Type},
+	}}}
+	if _, typ := params(n, single); typ != TypeUnknown {
+		return typ
+	}
+	if fd.force {
+		return TypeContext
+	}
+	return TypeUnknown
+}
+
+func findParam(ft *ast.FuncType, name string) *ast.Field {
+	if ft.Params == nil {
+		return nil
+	}
+	for _, p := range ft.Params.List {
+		for _, n := range p.Names {
+			if n.Name == name {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// fileDirectives returns the directives written before the package clause.
+func fileDirectives(f *ast.File) []directive {
+	var cgs []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		if cg.End() < f.Package {
+			cgs = append(cgs, cg)
+		}
+	}
+	return parseDirectives(cgs...)
+}
+
+func hasDirective(ds []directive, names ...string) bool {
+	for _, d := range ds {
+		for _, n := range names {
+			if d.prefix == "nrseg" && d.name == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ignoredFile reports whether the file or its package is ignored by the directives.
+func (cfg *Config) ignoredFile(fs *token.FileSet, f *ast.File) bool {
+	if hasDirective(fileDirectives(f), directiveIgnoreFile, directiveIgnorePackage) {
+		return true
+	}
+	fn := fs.File(f.Pos()).Name()
+	if len(fn) == 0 {
+		return false
+	}
+	return cfg.ignoredPackage(filepath.Dir(fn), f.Name.Name)
+}
+
+// ignoredPackage reports whether any file of the package in dir has nrseg:ignore-package.
+func (cfg *Config) ignoredPackage(dir, pkg string) bool {
+	key := dir + "\x00" + pkg
+	c := cfg.fsCache()
+	if c != nil {
+		if v, ok := c.ignoredPackages.Load(key); ok {
+			return v.(bool)
+		}
+	}
+	var ignored bool
+	es, _ := os.ReadDir(dir)
+	for _, e := range es {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".go" {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), e.Name(), src, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil || f.Name.Name != pkg {
+			continue
+		}
+		if hasDirective(fileDirectives(f), directiveIgnorePackage) {
+			ignored = true
+			break
+		}
+	}
+	if c != nil {
+		c.ignoredPackages.Store(key, ignored)
+	}
+	return ignored
+}
+
+// checkDirectives returns the issues of the invalid directives in f.
+func checkDirectives(fs *token.FileSet, f *ast.File, cfg *Config) []Issue {
+	var result []Issue
+	report := func(d directive, format string, args ...interface{}) {
+		result = append(result, Issue{Finding: cfg.directiveFinding(fs, f, d, format, args...), Pos: d.pos})
+	}
+
+	syntactic := cfg.syntacticParams(f)
+	params := cfg.directiveParams(fs, f, cfg.params(fs, f))
+	// attached is the positions of the directives which are attached to the functions or the package clause.
+	attached := map[token.Pos]bool{}
+	for _, d := range fileDirectives(f) {
+		if d.name == directiveIgnoreFile || d.name == directiveIgnorePackage {
+			attached[d.pos] = true
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		var ft *ast.FuncType
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			ft = n.Type
+		case *ast.FuncLit:
+			ft = n.Type
+		default:
+			return true
+		}
+		ds := parseDirectives(funcComments(fs, f, n)...)
+		if len(ds) == 0 {
+			return true
+		}
+		fd := newFuncDirectives(ds)
+		for _, d := range ds {
+			attached[d.pos] = true
+			if d.prefix != "nrseg" || !isKnownDirective(d.name) {
+				continue
+			}
+			switch d.name {
+			case directiveIgnoreFile, directiveIgnorePackage:
+				report(d, "must be written before the package clause")
+			case directiveCtx:
+				if len(d.args) != 1 {
+					break
+				}
+				if findParam(ft, d.args[0]) == nil {
+					report(d, "parameter %q is not found", d.args[0])
+				} else if ctxParamType(syntactic, n, ft, fd) == TypeUnknown {
+					report(d, "parameter %q does not have the context, add nrseg:force to use it as context.Context", d.args[0])
+				}
+			case directiveNoticeError:
+				if !returnsError(ft) {
+					report(d, "the function does not return error")
+				} else if !noticeable(ft) {
+					report(d, "the error result is named _, name it to notice the error")
+				}
+			case directiveForce:
+				// the function literal can capture the context of the enclosing function.
+				if _, ok := n.(*ast.FuncDecl); ok && len(fd.ctx) == 0 {
+					if _, typ := params(n, ft); typ == TypeUnknown {
+						report(d, "the context is not found, choose the parameter by nrseg:ctx")
+					}
+				}
+			}
+			if d.name != directiveIgnore && fd.ignore {
+				report(d, "conflicts with nrseg:ignore")
+			}
+		}
+		return true
+	})
+
+	for _, d := range parseDirectives(f.Comments...) {
+		if d.prefix != "nrseg" {
+			report(d, "directive must start with lowercase \"nrseg:\"")
+			continue
+		}
+		if !isKnownDirective(d.name) {
+			if s := suggestDirective(d.name); len(s) != 0 {
+				report(d, "unknown directive, did you mean nrseg:%s?", s)
+			} else {
+				report(d, "unknown directive")
+			}
+			continue
+		}
+		if msg := checkArgs(d); len(msg) != 0 {
+			report(d, "%s", msg)
+			continue
+		}
+		if !attached[d.pos] {
+			report(d, "is not attached to any function")
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Pos < result[j].Pos })
+	return result
+}
+
+func (cfg *Config) directiveFinding(fs *token.FileSet, f *ast.File, d directive, format string, args ...interface{}) Finding {
+	p := fs.Position(d.pos)
+	return Finding{
+		Rule:    RuleDirective,
+		File:    p.Filename,
+		Package: cfg.findingPackage(p.Filename, f.Name.Name),
+		Line:    p.Line,
+		Column:  p.Column,
+		Label:   d.prefix + ":" + d.name,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func isKnownDirective(name string) bool {
+	for _, k := range knownDirectives {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArgs returns the message if the arguments of d are invalid.
+func checkArgs(d directive) string {
+	switch d.name {
+	case directiveName, directiveCtx:
+		if len(d.args) != 1 {
+			return "needs exactly one argument"
+		}
+	case directiveForce, directiveNoticeError, directiveIgnoreFile, directiveIgnorePackage:
+		if len(d.args) != 0 {
+			return "does not take any arguments"
+		}
+	}
+	return ""
+}
+
+// suggestDirective returns the known directive which is similar to name.
+func suggestDirective(name string) string {
+	var best string
+	min := 3
+	for _, k := range knownDirectives {
+		if d := distance(strings.ToLower(name), k); d < min {
+			best, min = k, d
+		}
+	}
+	return best
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(ns ...int) int {
+	m := ns[0]
+	for _, n := range ns[1:] {
+		if n < m {
+			m = n
+		}
+	}
+	return m
+}
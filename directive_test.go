@@ -0,0 +1,328 @@
+package nrseg
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProcessWithConfig_Directives(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name string
+		cfg  *Config
+		src  string
+		want string
+	}{
+		{
+			name: "NameAndCtx",
+			src: `package sample
+
+import "context"
+
+// nrseg:name pinned
+func Pinned(ctx context.Context) {
+	_ = ctx
+}
+
+//nrseg:ctx reqCtx
+func Ctx(bg context.Context, reqCtx context.Context) {
+	_ = bg
+}
+`,
+			want: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// nrseg:name pinned
+func Pinned(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("pinned").End()
+	_ = ctx
+}
+
+//nrseg:ctx reqCtx
+func Ctx(bg context.Context, reqCtx context.Context) {
+	defer newrelic.FromContext(reqCtx).StartSegment("ctx").End()
+	_ = bg
+}
+`,
+		},
+		{
+			name: "Force",
+			cfg:  &Config{SkipFuncs: []string{"^Skipped$"}},
+			src: `package sample
+
+import "context"
+
+type myCtx interface {
+	context.Context
+}
+
+// nrseg:force
+func Skipped(ctx context.Context) {
+	_ = ctx
+}
+
+// nrseg:force
+// nrseg:ctx c
+func Custom(c myCtx) {
+	_ = c
+}
+
+func Deferred(ctx context.Context) {
+	// nrseg:force
+	defer func(ctx context.Context) {
+		_ = ctx
+	}(ctx)
+}
+`,
+			want: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type myCtx interface {
+	context.Context
+}
+
+// nrseg:force
+func Skipped(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("skipped").End()
+	_ = ctx
+}
+
+// nrseg:force
+// nrseg:ctx c
+func Custom(c myCtx) {
+	defer newrelic.FromContext(c).StartSegment("custom").End()
+	_ = c
+}
+
+func Deferred(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("deferred").End()
+	// nrseg:force
+	defer func(ctx context.Context) {
+		defer newrelic.FromContext(ctx).StartSegment("deferred_func1").End()
+		_ = ctx
+	}(ctx)
+}
+`,
+		},
+		{
+			name: "Ignore",
+			src: `package sample
+
+import (
+	"context"
+)
+
+// nrseg:ignore
+func NoReason(ctx context.Context) {
+	_ = ctx
+}
+
+//nrseg:ignore no space
+func NoSpace(ctx context.Context) {
+	_ = ctx
+}
+`,
+			want: `package sample
+
+import (
+	"context"
+)
+
+// nrseg:ignore
+func NoReason(ctx context.Context) {
+	_ = ctx
+}
+
+//nrseg:ignore no space
+func NoSpace(ctx context.Context) {
+	_ = ctx
+}
+`,
+		},
+		{
+			name: "IgnoreFile",
+			src: `//nrseg:ignore-file
+
+package sample
+
+import "context"
+
+func SampleFunc(ctx context.Context) {
+	_ = ctx
+}
+`,
+			want: `//nrseg:ignore-file
+
+package sample
+
+import "context"
+
+func SampleFunc(ctx context.Context) {
+	_ = ctx
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if tt.cfg != nil {
+				if err := tt.cfg.Compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := ProcessWithConfig("sample.go", []byte(tt.src), tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestProcess_IgnorePackage(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	doc := "// Package sample is generated by hand.\n//nrseg:ignore-package\npackage sample\n"
+	if err := os.WriteFile(filepath.Join(dir, "doc.go"), []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := []byte("package sample\n\nimport \"context\"\n\nfunc SampleFunc(ctx context.Context) {\n\t_ = ctx\n}\n")
+	got, err := Process(filepath.Join(dir, "sample.go"), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), string(src)); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	// the directive is cached by the configuration, the new configuration sees the edit.
+	cfg := &Config{}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ignoredPackage(dir, "sample") {
+		t.Fatal("the package must be ignored")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "doc.go"), []byte("package sample\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ignoredPackage(dir, "sample") {
+		t.Error("the result must be cached by the configuration")
+	}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ignoredPackage(dir, "sample") {
+		t.Error("the cache must be cleared by Compile")
+	}
+}
+
+func TestCheck_Directives(t *testing.T) {
+	t.Parallel()
+	src := `//nrseg:ignore-file unexpected
+
+package sample
+
+import (
+	"context"
+	"net/http"
+)
+
+// nrseg:ignroe typo
+func Typo(ctx context.Context) {
+	_ = ctx
+}
+
+// NRSEG:ignore upper case
+func Upper(ctx context.Context) {
+	_ = ctx
+}
+
+// nrseg:ctx req
+func NotFound(ctx context.Context) {
+	_ = ctx
+}
+
+// nrseg:ctx n
+func NotContext(ctx context.Context, n int) {
+	_ = ctx
+}
+
+// nrseg:force
+func NoContext(n int) {
+	_ = n
+}
+
+// nrseg:ignore-package
+// nrseg:name
+func Misplaced(ctx context.Context) {
+	_ = ctx
+}
+
+// nrseg:ignore
+// nrseg:name conflicted
+func Conflict(ctx context.Context) {
+	_ = ctx
+}
+
+// nrseg:name floating
+
+func Handler(w http.ResponseWriter, req *http.Request) {
+	_ = req
+}
+`
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, "sample.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := Check(fs, f, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		Line    int
+		Label   string
+		Message string
+	}
+	var got []result
+	for _, i := range is {
+		if i.Rule == RuleDirective {
+			got = append(got, result{Line: i.Line, Label: i.Label, Message: i.Message})
+		}
+	}
+	want := []result{
+		{Line: 1, Label: "nrseg:ignore-file", Message: "does not take any arguments"},
+		{Line: 10, Label: "nrseg:ignroe", Message: "unknown directive, did you mean nrseg:ignore?"},
+		{Line: 15, Label: "NRSEG:ignore", Message: `directive must start with lowercase "nrseg:"`},
+		{Line: 20, Label: "nrseg:ctx", Message: `parameter "req" is not found`},
+		{Line: 25, Label: "nrseg:ctx", Message: `parameter "n" does not have the context, add nrseg:force to use it as context.Context`},
+		{Line: 30, Label: "nrseg:force", Message: "the context is not found, choose the parameter by nrseg:ctx"},
+		{Line: 35, Label: "nrseg:ignore-package", Message: "must be written before the package clause"},
+		{Line: 36, Label: "nrseg:name", Message: "needs exactly one argument"},
+		{Line: 42, Label: "nrseg:name", Message: "conflicts with nrseg:ignore"},
+		{Line: 47, Label: "nrseg:name", Message: "is not attached to any function"},
+	}
+	if diff := cmp.Diff(got, want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
@@ -0,0 +1,80 @@
+package nrseg
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of the command.
+const (
+	ExitOK = 0
+	// ExitFindings is returned when inspect finds functions or -l lists files.
+	ExitFindings = 1
+	// ExitUsage is returned when the arguments or the configuration are invalid.
+	ExitUsage = 2
+	// ExitError is returned when some files could not be processed.
+	ExitError = 3
+)
+
+// FileError is the error which occurred while processing the file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// FileErrors is the errors of all files which could not be processed.
+// The other files are processed even if some files have errors.
+type FileErrors []*FileError
+
+func (es FileErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("%d file(s) could not be processed:\n%s", len(es), strings.Join(msgs, "\n"))
+}
+
+func (es FileErrors) Unwrap() []error {
+	errs := make([]error, 0, len(es))
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// UsageError is the error of the command line arguments or the configuration.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code of the command for err returned by Run.
+func ExitCode(err error) int {
+	var ue *UsageError
+	switch {
+	case err == nil, errors.Is(err, ErrShowVersion), errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, ErrFlagTrue):
+		return ExitFindings
+	case errors.As(err, &ue):
+		return ExitUsage
+	}
+	return ExitError
+}
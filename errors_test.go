@@ -0,0 +1,108 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "nil", err: nil, want: ExitOK},
+		{name: "version", err: ErrShowVersion, want: ExitOK},
+		{name: "help", err: flag.ErrHelp, want: ExitOK},
+		{name: "findings", err: ErrFlagTrue, want: ExitFindings},
+		{name: "usage", err: &UsageError{Err: errors.New("bad flag")}, want: ExitUsage},
+		{name: "files", err: FileErrors{{Path: "a.go", Err: errors.New("bad file")}}, want: ExitError},
+		{name: "other", err: errors.New("other"), want: ExitError},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNrseg_Run_FileErrors(t *testing.T) {
+	dir := configDir(t)
+	valid := `package main
+
+import "context"
+
+func SampleFunc(ctx context.Context) {
+	_ = ctx
+}
+`
+	files := map[string]string{
+		"a.go": "package main\n\nfunc Broken( {\n",
+		"b.go": valid,
+		"c.go": "package main\n\nfunc Broken() {\n",
+	}
+	for n, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, n), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	err := Run([]string{"nrseg", dir}, out, errs, "", "")
+	var fes FileErrors
+	if !errors.As(err, &fes) {
+		t.Fatalf("want FileErrors, but got %v", err)
+	}
+	if got := ExitCode(err); got != ExitError {
+		t.Errorf("ExitCode() = %d, want %d", got, ExitError)
+	}
+	if len(fes) != 2 || fes[0].Path != filepath.Join(dir, "a.go") || fes[1].Path != filepath.Join(dir, "c.go") {
+		t.Errorf("unexpected errors: %v", fes)
+	}
+	if !strings.Contains(errs.String(), "2 file(s) could not be processed") {
+		t.Errorf("errors are not reported: %q", errs.String())
+	}
+	got, rerr := os.ReadFile(filepath.Join(dir, "b.go"))
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if !bytes.Contains(got, []byte(`StartSegment("sample_func")`)) {
+		t.Errorf("the valid file is not processed:\n%s", got)
+	}
+}
+
+func TestNrseg_Run_UsageError(t *testing.T) {
+	tests := [...]struct {
+		name string
+		args []string
+	}{
+		{name: "unknownFlag", args: []string{"nrseg", "-unknown", "./testdata/input"}},
+		{name: "unknownFormat", args: []string{"nrseg", "inspect", "-format", "xml", "./testdata/input"}},
+		{name: "unknownBackend", args: []string{"nrseg", "-backend", "zipkin", "./testdata/input"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			errs := &bytes.Buffer{}
+			err := Run(tt.args, out, errs, "", "")
+			if got := ExitCode(err); got != ExitUsage {
+				t.Errorf("ExitCode(%v) = %d, want %d", err, got, ExitUsage)
+			}
+			if errs.Len() == 0 {
+				t.Error("the error is not reported")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package nrseg
+
+import (
+	"bytes"
+	"io"
+)
+
+// stdinName is the file name in the messages if -filename is not set.
+const stdinName = "<standard input>"
+
+// filter processes the source read from stdin instead of the files, and writes the result to the output.
+// The source is written as it is if -filename is the file which nrseg does not process.
+// Nothing is written to the output if the source cannot be processed.
+func (n *nrseg) filter() error {
+	org, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	path := n.filename
+	name := path
+	if len(name) == 0 {
+		name = stdinName
+	}
+	target := len(path) == 0 || (n.isTarget(path) && !n.inIgnoredDir(path))
+
+	r := &fileResult{path: name}
+	switch {
+	case n.mode == modeInspect:
+		if target {
+			r.err = n.inspect(r, file{path: path}, org)
+		}
+		for i := range r.findings {
+			r.findings[i].File = name
+		}
+	case n.mode == modeStats:
+		if target {
+			r.err = n.stat(r, name, org)
+		}
+	case !target:
+		if !n.list && !n.diff {
+			r.out.Write(org)
+		}
+	default:
+		got, err := n.rewrite(r, path, org)
+		switch {
+		case err != nil:
+			r.err = err
+		case n.list || n.diff:
+			if !bytes.Equal(org, got) {
+				r.err = n.report(r, name, org, got)
+			}
+		default:
+			r.out.Write(got)
+			// the output is the source, so the renames are written to the error output.
+			writeRenames(n.errStream, r.renames)
+		}
+	}
+	if r.err != nil {
+		return FileErrors{{Path: name, Err: r.err}}
+	}
+	n.findings = r.findings
+	for _, e := range r.inspected {
+		n.inspected[e] = true
+	}
+	if len(r.funcs) != 0 {
+		n.funcs[r.pkg] = r.funcs
+	}
+	for i := range r.unwrapped {
+		r.unwrapped[i].File = name
+	}
+	n.unwrapped = r.unwrapped
+	n.errFlag = r.flag
+	_, err = n.outStream.Write(r.out.Bytes())
+	return err
+}
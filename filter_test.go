@@ -0,0 +1,110 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNrseg_Run_Stdin(t *testing.T) {
+	read := func(t *testing.T, path string) string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	basic := read(t, "testdata/input/basic.go")
+	tests := [...]struct {
+		name    string
+		args    []string
+		src     string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "default",
+			args: []string{"nrseg", "-stdin", "-filename", "example/basic.go"},
+			src:  basic,
+			want: read(t, "testdata/want/basic.go"),
+		},
+		{
+			name: "remove",
+			args: []string{"nrseg", "remove", "-stdin", "-filename", "example/basic.go"},
+			src:  read(t, "testdata/want/basic.go"),
+			want: basic,
+		},
+		{
+			name: "test file",
+			args: []string{"nrseg", "-stdin", "-filename", "example/basic_test.go"},
+			src:  basic,
+			want: basic,
+		},
+		{
+			name: "ignored dir",
+			args: []string{"nrseg", "-stdin", "-i", "example", "-filename", "example/basic.go"},
+			src:  basic,
+			want: basic,
+		},
+		{
+			name: "generated",
+			args: []string{"nrseg", "-stdin", "-filename", "example/auto_generated.go"},
+			src:  read(t, "testdata/input/auto_generated.go"),
+			want: read(t, "testdata/want/auto_generated.go"),
+		},
+		{
+			name: "list",
+			args: []string{"nrseg", "-l", "-stdin", "-filename", "example/basic.go"},
+			src:  basic,
+			want: "example/basic.go\n",
+
+			wantErr: ErrFlagTrue,
+		},
+		{
+			name: "inspect",
+			args: []string{"nrseg", "inspect", "-stdin"},
+			src:  basic,
+			want: `<standard input>:11:1: S.SampleMethod no insert segment
+<standard input>:16:1: SampleFunc no insert segment
+<standard input>:21:1: SampleHandler no insert segment
+`,
+			wantErr: ErrFlagTrue,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			org := stdin
+			defer func() { stdin = org }()
+			stdin = bytes.NewBufferString(tt.src)
+			out := &bytes.Buffer{}
+			errs := &bytes.Buffer{}
+			if err := Run(tt.args, out, errs, "", ""); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want %v, but got %v: %s", tt.wantErr, err, errs)
+			}
+			if diff := cmp.Diff(out.String(), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestNrseg_Run_StdinError(t *testing.T) {
+	org := stdin
+	defer func() { stdin = org }()
+	stdin = bytes.NewBufferString("package main\n\nfunc (")
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	err := Run([]string{"nrseg", "-stdin", "-filename", "main.go"}, out, errs, "", "")
+	var fes FileErrors
+	if !errors.As(err, &fes) || len(fes) != 1 || fes[0].Path != "main.go" {
+		t.Fatalf("want FileErrors of main.go, but got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("want no output, but got %q", out)
+	}
+}
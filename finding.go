@@ -0,0 +1,264 @@
+package nrseg
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Output formats of inspect subcommand.
+const (
+	FormatText       = "text"
+	FormatJSON       = "json"
+	FormatSARIF      = "sarif"
+	FormatCheckstyle = "checkstyle"
+	FormatGitHub     = "github"
+)
+
+// Rules of the findings.
+const (
+	// RuleMissingSegment is the function which has the context but does not call the segment.
+	RuleMissingSegment = "missing-segment"
+	// RuleSegmentName is the segment whose name does not match the naming style.
+	RuleSegmentName = "segment-name"
+	// RuleDirective is the nrseg: directive which is unknown or invalid.
+	RuleDirective = "invalid-directive"
+	// RuleGoroutine is the goroutine which uses the context without NewGoroutine.
+	RuleGoroutine = "unpropagated-goroutine"
+)
+
+var ruleDescriptions = map[string]string{
+	RuleMissingSegment: "Function has context but does not start a segment.",
+	RuleSegmentName:    "Segment name does not match the naming style.",
+	RuleDirective:      "nrseg directive is unknown or invalid.",
+	RuleGoroutine:      "Goroutine uses the context without NewGoroutine.",
+}
+
+// Finding is the problem reported by inspect subcommand.
+type Finding struct {
+	Rule string `json:"rule"`
+	File string `json:"file"`
+	// Package is the import path of the package, or its name if go.mod is not found.
+	Package  string `json:"package"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Receiver string `json:"receiver,omitempty"`
+	Function string `json:"function"`
+	Literal  string `json:"literal,omitempty"`
+	// Label is the human readable name of the function. ex: S.Method, SampleFunc.func1
+	Label string `json:"label"`
+	// Context is the type of the parameter which has the context. ex: context.Context, *http.Request
+	Context string `json:"context"`
+	// Segment is the suggested segment name.
+	Segment string `json:"segment"`
+	// Current is the segment name in the function. It is set only by RuleSegmentName.
+	Current string `json:"current,omitempty"`
+	Message string `json:"message"`
+}
+
+func newFinding(fs *token.FileSet, t *funcTarget, rule, format string, args ...interface{}) Finding {
+	p := fs.Position(t.node.Pos())
+	f := Finding{
+		Rule:    rule,
+		File:    p.Filename,
+		Line:    p.Line,
+		Column:  p.Column,
+		Label:   t.label,
+		Context: t.typ,
+		Segment: t.name,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if t.nd != nil {
+		f.Receiver, f.Function, f.Literal = t.nd.Receiver, t.nd.Function, t.nd.Literal
+		f.Package = t.nd.ImportPath
+		if len(f.Package) == 0 {
+			f.Package = t.nd.Package
+		}
+	}
+	return f
+}
+
+func validFormat(format string) bool {
+	switch format {
+	case FormatText, FormatJSON, FormatSARIF, FormatCheckstyle, FormatGitHub:
+		return true
+	}
+	return false
+}
+
+// writeFindings writes fds to w in format.
+func writeFindings(w io.Writer, format string, fds []Finding) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		for _, f := range fds {
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatSARIF:
+		return writeSARIF(w, fds)
+	case FormatCheckstyle:
+		return writeCheckstyle(w, fds)
+	case FormatGitHub:
+		for _, f := range fds {
+			fmt.Fprintf(w, "::warning file=%s,line=%d,col=%d,title=%s::%s\n",
+				escapeProperty(filepath.ToSlash(f.File)), f.Line, f.Column,
+				escapeProperty("nrseg "+f.Rule), escapeData(f.Label+" "+f.Message))
+		}
+		return nil
+	}
+	for _, f := range fds {
+		fmt.Fprintf(w, "%s:%d:%d: %s %s\n", f.File, f.Line, f.Column, f.Label, f.Message)
+	}
+	return nil
+}
+
+// https://github.com/actions/toolkit/blob/main/packages/core/src/command.ts
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
+
+func writeSARIF(w io.Writer, fds []Finding) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "nrseg",
+			InformationURI: "https://github.com/budougumi0617/nrseg",
+		}},
+		Results: []sarifResult{},
+	}
+	for _, id := range []string{RuleMissingSegment, RuleSegmentName, RuleDirective, RuleGoroutine} {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               id,
+			ShortDescription: sarifMessage{Text: ruleDescriptions[id]},
+		})
+	}
+	for _, f := range fds {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  f.Rule,
+			Level:   "warning",
+			Message: sarifMessage{Text: f.Label + " " + f.Message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(f.File)},
+				Region:           sarifRegion{StartLine: f.Line, StartColumn: f.Column},
+			}}},
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
+
+type checkstyleResult struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Column   int    `xml:"column,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+func writeCheckstyle(w io.Writer, fds []Finding) error {
+	cr := checkstyleResult{Version: "4.3"}
+	for _, f := range fds {
+		if len(cr.Files) == 0 || cr.Files[len(cr.Files)-1].Name != f.File {
+			cr.Files = append(cr.Files, checkstyleFile{Name: f.File})
+		}
+		cf := &cr.Files[len(cr.Files)-1]
+		cf.Errors = append(cf.Errors, checkstyleError{
+			Line:     f.Line,
+			Column:   f.Column,
+			Severity: "warning",
+			Message:  f.Label + " " + f.Message,
+			Source:   "nrseg." + f.Rule,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(cr); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,120 @@
+package nrseg
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_writeFindings(t *testing.T) {
+	t.Parallel()
+	fds := []Finding{
+		{
+			Rule: RuleMissingSegment, File: "foo/a.go", Line: 3, Column: 1,
+			Package: "example.com/foo", Receiver: "S", Function: "Method", Label: "S.Method",
+			Context: TypeContext, Segment: "s_method", Message: "no insert segment",
+		},
+		{
+			Rule: RuleSegmentName, File: "foo/a.go", Line: 9, Column: 8,
+			Package: "example.com/foo", Function: "Func", Literal: "func1", Label: "Func.func1",
+			Context: TypeHttpRequest, Segment: "func_func1", Current: "a,b",
+			Message: `segment name "a,b" does not match "func_func1"`,
+		},
+		{
+			Rule: RuleMissingSegment, File: "foo/b.go", Line: 5, Column: 1,
+			Package: "example.com/foo", Function: "F", Label: "F", Context: TypeContext, Segment: "f", Message: "no insert segment",
+		},
+	}
+	tests := [...]struct {
+		format, want string
+	}{
+		{
+			format: FormatText,
+			want: `foo/a.go:3:1: S.Method no insert segment
+foo/a.go:9:8: Func.func1 segment name "a,b" does not match "func_func1"
+foo/b.go:5:1: F no insert segment
+`,
+		},
+		{
+			format: FormatJSON,
+			want: `{"rule":"missing-segment","file":"foo/a.go","package":"example.com/foo","line":3,"column":1,"receiver":"S","function":"Method","label":"S.Method","context":"context.Context","segment":"s_method","message":"no insert segment"}
+{"rule":"segment-name","file":"foo/a.go","package":"example.com/foo","line":9,"column":8,"function":"Func","literal":"func1","label":"Func.func1","context":"*http.Request","segment":"func_func1","current":"a,b","message":"segment name \"a,b\" does not match \"func_func1\""}
+{"rule":"missing-segment","file":"foo/b.go","package":"example.com/foo","line":5,"column":1,"function":"F","label":"F","context":"context.Context","segment":"f","message":"no insert segment"}
+`,
+		},
+		{
+			format: FormatGitHub,
+			want: `::warning file=foo/a.go,line=3,col=1,title=nrseg missing-segment::S.Method no insert segment
+::warning file=foo/a.go,line=9,col=8,title=nrseg segment-name::Func.func1 segment name "a,b" does not match "func_func1"
+::warning file=foo/b.go,line=5,col=1,title=nrseg missing-segment::F no insert segment
+`,
+		},
+		{
+			format: FormatCheckstyle,
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<checkstyle version="4.3">
+  <file name="foo/a.go">
+    <error line="3" column="1" severity="warning" message="S.Method no insert segment" source="nrseg.missing-segment"></error>
+    <error line="9" column="8" severity="warning" message="Func.func1 segment name &#34;a,b&#34; does not match &#34;func_func1&#34;" source="nrseg.segment-name"></error>
+  </file>
+  <file name="foo/b.go">
+    <error line="5" column="1" severity="warning" message="F no insert segment" source="nrseg.missing-segment"></error>
+  </file>
+</checkstyle>
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.format, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			if err := writeFindings(&buf, tt.format, fds); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(buf.String(), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func Test_writeFindings_SARIF(t *testing.T) {
+	t.Parallel()
+	fds := []Finding{{
+		Rule: RuleMissingSegment, File: "foo/a.go", Line: 3, Column: 1,
+		Label: "S.Method", Message: "no insert segment",
+	}}
+	var buf bytes.Buffer
+	if err := writeFindings(&buf, FormatSARIF, fds); err != nil {
+		t.Fatal(err)
+	}
+	var got sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []sarifResult{{
+		RuleID:  RuleMissingSegment,
+		Level:   "warning",
+		Message: sarifMessage{Text: "S.Method no insert segment"},
+		Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: "foo/a.go"},
+			Region:           sarifRegion{StartLine: 3, StartColumn: 1},
+		}}},
+	}}
+	if got.Version != "2.1.0" || len(got.Runs) != 1 {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+	if diff := cmp.Diff(got.Runs[0].Results, want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
+
+func Test_escapeProperty(t *testing.T) {
+	t.Parallel()
+	if got, want := escapeProperty("a:b,c%\n"), "a%3Ab%2Cc%25%0A"; got != want {
+		t.Errorf("escapeProperty() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package nrseg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	gotypes "go/types"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ContextType is the parameter type of the framework which provides context.Context.
+type ContextType struct {
+	// ImportPath is the import path of the package which declares the type. ex: github.com/gin-gonic/gin
+	ImportPath string `yaml:"import_path"`
+	// Type is the type name. It starts with "*" if the parameter is the pointer. ex: *Context
+	Type string `yaml:"type"`
+	// Context is the Go template of the expression which returns context.Context.
+	// The variable name is passed as the data. ex: {{.}}.Request.Context()
+	Context string `yaml:"context"`
+}
+
+// builtinContextTypes is the known framework types.
+// chi is not here because its handlers use *http.Request.
+var builtinContextTypes = []ContextType{
+	{ImportPath: "github.com/labstack/echo/v4", Type: "Context", Context: "{{.}}.Request().Context()"},
+	{ImportPath: "github.com/labstack/echo", Type: "Context", Context: "{{.}}.Request().Context()"},
+	{ImportPath: "github.com/gin-gonic/gin", Type: "*Context", Context: "{{.}}.Request.Context()"},
+	{ImportPath: "github.com/gofiber/fiber/v2", Type: "*Ctx", Context: "{{.}}.UserContext()"},
+	{ImportPath: "google.golang.org/grpc", Type: "ServerStream", Context: "{{.}}.Context()"},
+}
+
+// contextType is the compiled ContextType.
+type contextType struct {
+	ContextType
+	tmpl *template.Template
+}
+
+func compileContextTypes(cts []ContextType) ([]*contextType, error) {
+	var result []*contextType
+	// user's types take precedence over the built-in types.
+	for _, ct := range append(cts[:len(cts):len(cts)], builtinContextTypes...) {
+		if len(ct.ImportPath) == 0 || len(strings.TrimPrefix(ct.Type, "*")) == 0 || len(ct.Context) == 0 {
+			return nil, fmt.Errorf("context type needs import_path, type and context: %+v", ct)
+		}
+		tmpl, err := template.New(ct.Type).Parse(ct.Context)
+		if err != nil {
+			return nil, fmt.Errorf("bad context of %s: %w", ct.key(), err)
+		}
+		c := &contextType{ContextType: ct, tmpl: tmpl}
+		if _, err := c.expr("c"); err != nil {
+			return nil, fmt.Errorf("bad context of %s: %w", ct.key(), err)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// key returns the type of the parameter used as Target.Type. ex: *gin.Context
+func (ct ContextType) key() string {
+	n := strings.TrimPrefix(ct.Type, "*")
+	return strings.Repeat("*", len(ct.Type)-len(n)) + defaultPkgName(ct.ImportPath) + "." + n
+}
+
+// expr returns the expression which returns context.Context from the variable vn.
+func (ct *contextType) expr(vn string) (string, error) {
+	var buf bytes.Buffer
+	if err := ct.tmpl.Execute(&buf, vn); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// match reports whether the parameter type expression e is ct.
+// pkg is the package name of ct.ImportPath in the file.
+func (ct *contextType) match(pkg string, e ast.Expr) bool {
+	n := ct.Type
+	if se, ok := e.(*ast.StarExpr); ok {
+		if !strings.HasPrefix(n, "*") {
+			return false
+		}
+		n, e = n[1:], se.X
+	}
+	se, ok := e.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != n {
+		return false
+	}
+	idt, ok := se.X.(*ast.Ident)
+	return ok && idt.Name == pkg
+}
+
+// matchType reports whether t is ct.
+func (ct *contextType) matchType(t gotypes.Type) bool {
+	n := ct.Type
+	if p, ok := t.(*gotypes.Pointer); ok {
+		if !strings.HasPrefix(n, "*") {
+			return false
+		}
+		n, t = n[1:], p.Elem()
+	}
+	nt, ok := t.(*gotypes.Named)
+	if !ok {
+		return false
+	}
+	o := nt.Obj()
+	return o.Pkg() != nil && o.Pkg().Path() == ct.ImportPath && o.Name() == n
+}
+
+// parseContextTypes returns the first parameter whose type is one of cts.
+func parseContextTypes(is []*ast.ImportSpec, t *ast.FuncType, cts []*contextType) (string, string) {
+	for _, f := range t.Params.List {
+		name := paramName(f)
+		if len(name) == 0 {
+			continue
+		}
+		for _, ct := range cts {
+			if pkg, ok := importName(is, ct.ImportPath); ok && ct.match(pkg, f.Type) {
+				return name, ct.key()
+			}
+		}
+	}
+	return "", TypeUnknown
+}
+
+// importName returns the package name of ip in the file.
+func importName(is []*ast.ImportSpec, ip string) (string, bool) {
+	for _, i := range is {
+		if i.Path == nil {
+			continue
+		}
+		if p, err := strconv.Unquote(i.Path.Value); err != nil || p != ip {
+			continue
+		}
+		if i.Name != nil {
+			return i.Name.Name, true
+		}
+		return defaultPkgName(ip), true
+	}
+	return "", false
+}
+
+// contextTypes returns the compiled context types. The built-in types are used if cfg is not compiled.
+func (cfg *Config) contextTypes() []*contextType {
+	if cfg != nil && cfg.ctxTypes != nil {
+		return cfg.ctxTypes
+	}
+	cts, _ := compileContextTypes(nil)
+	return cts
+}
+
+// ctxExpr returns the expression which returns context.Context from the variable vn of typ.
+func (cfg *Config) ctxExpr(typ, vn string) string {
+	switch typ {
+	case TypeContext:
+		return vn
+	case TypeHttpRequest:
+		return vn + ".Context()"
+	}
+	for _, ct := range cfg.contextTypes() {
+		if ct.key() == typ {
+			if e, err := ct.expr(vn); err == nil {
+				return e
+			}
+		}
+	}
+	return vn
+}
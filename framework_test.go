@@ -0,0 +1,314 @@
+package nrseg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProcessWithConfig_ContextTypes(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, src, want string
+		cts             []ContextType
+	}{
+		{
+			name: "Echo",
+			src: `package main
+
+import "github.com/labstack/echo/v4"
+
+func SampleHandler(c echo.Context) error {
+	return nil
+}
+`,
+			want: `package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleHandler(c echo.Context) error {
+	defer newrelic.FromContext(c.Request().Context()).StartSegment("sample_handler").End()
+	return nil
+}
+`,
+		},
+		{
+			name: "GinWithAlias",
+			src: `package main
+
+import g "github.com/gin-gonic/gin"
+
+func SampleHandler(gc *g.Context) {
+	gc.Status(200)
+}
+`,
+			want: `package main
+
+import (
+	g "github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleHandler(gc *g.Context) {
+	defer newrelic.FromContext(gc.Request.Context()).StartSegment("sample_handler").End()
+	gc.Status(200)
+}
+`,
+		},
+		{
+			name: "Fiber",
+			src: `package main
+
+import "github.com/gofiber/fiber/v2"
+
+func SampleHandler(c *fiber.Ctx) error {
+	return nil
+}
+`,
+			want: `package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleHandler(c *fiber.Ctx) error {
+	defer newrelic.FromContext(c.UserContext()).StartSegment("sample_handler").End()
+	return nil
+}
+`,
+		},
+		{
+			name: "GRPCStream",
+			src: `package main
+
+import "google.golang.org/grpc"
+
+type S struct{}
+
+func (s *S) Watch(req *Req, stream grpc.ServerStream) error {
+	return nil
+}
+`,
+			want: `package main
+
+import (
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"google.golang.org/grpc"
+)
+
+type S struct{}
+
+func (s *S) Watch(req *Req, stream grpc.ServerStream) error {
+	defer newrelic.FromContext(stream.Context()).StartSegment("s_watch").End()
+	return nil
+}
+`,
+		},
+		{
+			name: "PreferContext",
+			src: `package main
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+)
+
+func SampleFunc(c echo.Context, ctx context.Context) error {
+	return nil
+}
+`,
+			want: `package main
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(c echo.Context, ctx context.Context) error {
+	defer newrelic.FromContext(ctx).StartSegment("sample_func").End()
+	return nil
+}
+`,
+		},
+		{
+			name: "NotPointer",
+			src: `package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func SampleFunc(c gin.Context) {
+	c.Status(200)
+}
+`,
+			want: `package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func SampleFunc(c gin.Context) {
+	c.Status(200)
+}
+`,
+		},
+		{
+			name: "Custom",
+			cts:  []ContextType{{ImportPath: "example.com/web", Type: "*Ctx", Context: "{{.}}.Std()"}},
+			src: `package main
+
+import "example.com/web"
+
+func SampleHandler(wc *web.Ctx) {
+	wc.OK()
+}
+`,
+			want: `package main
+
+import (
+	"example.com/web"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleHandler(wc *web.Ctx) {
+	defer newrelic.FromContext(wc.Std()).StartSegment("sample_handler").End()
+	wc.OK()
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := &Config{ContextTypes: tt.cts}
+			if err := cfg.Compile(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ProcessWithConfig("", []byte(tt.src), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+
+			rm, err := RemoveWithConfig("", got, cfg, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(rm), "newrelic") {
+				t.Errorf("remove() did not remove the segment:\n%s", rm)
+			}
+		})
+	}
+}
+
+func TestContextType_key(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		ct   ContextType
+		want string
+	}{
+		{ct: ContextType{ImportPath: "github.com/labstack/echo/v4", Type: "Context"}, want: "echo.Context"},
+		{ct: ContextType{ImportPath: "github.com/gin-gonic/gin", Type: "*Context"}, want: "*gin.Context"},
+		{ct: ContextType{ImportPath: "google.golang.org/grpc", Type: "ServerStream"}, want: "grpc.ServerStream"},
+	}
+	for _, tt := range tests {
+		if got := tt.ct.key(); got != tt.want {
+			t.Errorf("key() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestConfig_params_SamePriority(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	src := `package main
+
+import (
+	"context"
+	"net/http"
+
+	"example.com/prio/a"
+	"example.com/prio/b"
+)
+
+func Both(c *b.Ctx, d *a.Context) {}
+
+func BlankContext(_ context.Context, c *a.Context) {}
+
+func ContextLast(c *a.Context, ctx context.Context) {}
+
+func Requests(w http.ResponseWriter, r1 *http.Request, r2 *http.Request) {}
+
+func BlankRequest(_ *http.Request, r *http.Request) {}
+`
+	files := map[string]string{
+		"go.mod":  "module example.com/prio\n\ngo 1.21\n",
+		"main.go": src,
+		"a/a.go":  "package a\n\ntype Context struct{}\n",
+		"b/b.go":  "package b\n\ntype Ctx struct{}\n",
+	}
+	for name, body := range files {
+		fn := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{ContextTypes: []ContextType{
+		{ImportPath: "example.com/prio/a", Type: "*Context", Context: "{{.}}.Ctx()"},
+		{ImportPath: "example.com/prio/b", Type: "*Ctx", Context: "{{.}}.Ctx()"},
+	}}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.LoadTypes(dir); err != nil {
+		t.Fatal(err)
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, filepath.Join(dir, "main.go"), src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]string{
+		"Both":         {"c", "*b.Ctx"},
+		"BlankContext": {"c", "*a.Context"},
+		"ContextLast":  {"ctx", TypeContext},
+		"Requests":     {"r1", TypeHttpRequest},
+		"BlankRequest": {"r", TypeHttpRequest},
+	}
+	typed, syntactic := cfg.params(fs, f), cfg.syntacticParams(f)
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		var got [2]string
+		got[0], got[1] = typed(fd, fd.Type)
+		if diff := cmp.Diff(got, want[fd.Name.Name]); diff != "" {
+			t.Errorf("typed %s -got +want %v", fd.Name.Name, diff)
+		}
+		got[0], got[1] = syntactic(fd, fd.Type)
+		if diff := cmp.Diff(got, want[fd.Name.Name]); diff != "" {
+			t.Errorf("syntactic %s -got +want %v", fd.Name.Name, diff)
+		}
+	}
+}
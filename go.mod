@@ -1,13 +1,13 @@
 module github.com/budougumi0617/nrseg
 
-go 1.23
+go 1.25.0
 
 require (
-	github.com/google/go-cmp v0.5.4
-	golang.org/x/tools v0.0.0-20210101214203-2dba1e4ea05c
+	github.com/google/go-cmp v0.6.0
+	github.com/pmezard/go-difflib v1.0.0
+	golang.org/x/mod v0.35.0
+	golang.org/x/tools v0.44.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	golang.org/x/mod v0.3.0 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
-)
+require golang.org/x/sync v0.20.0 // indirect
@@ -0,0 +1,293 @@
+package nrseg
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	gotypes "go/types"
+)
+
+var errNewGoroutine = errors.New("new_goroutine is supported only by newrelic backend")
+
+// goroutine is the go statement which uses the context of the function without NewGoroutine.
+type goroutine struct {
+	stmt *ast.GoStmt
+	// captured is true if the function literal of stmt captures the variable.
+	captured bool
+	// assigned is true if the function literal assigns the captured variable.
+	// The variable can not be passed as the parameter, because the assignment must update the outer variable.
+	assigned bool
+	// declared is true if the function literal declares the variable of the same name in the top of its body.
+	// The parameter would be redeclared by the declaration.
+	declared bool
+	// args is the indexes of the arguments which pass the variable or the context.
+	args []int
+}
+
+// findGoroutines returns the go statements in the body of t which use the context without NewGoroutine.
+// The go statements in the other function literals are found by their targets.
+// Only context.Context and *http.Request are supported, the other types can not be rebound.
+func findGoroutines(f *ast.File, t *funcTarget) []goroutine {
+	if t.obj == nil || len(goroutineParamType(f, t)) == 0 {
+		return nil
+	}
+	var gs []goroutine
+	ast.Inspect(t.body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.GoStmt:
+			g := goroutine{stmt: n}
+			for i, a := range n.Call.Args {
+				if isVarOf(a, t.obj) || (t.typ == TypeHttpRequest && isReqContext(a, t.obj)) {
+					g.args = append(g.args, i)
+				}
+			}
+			if fl, ok := n.Call.Fun.(*ast.FuncLit); ok {
+				g.captured = refer(fl.Body, t.obj)
+				g.assigned = g.captured && assigns(fl.Body, t.obj)
+				g.declared = g.captured && declares(fl.Body, t.vn)
+			}
+			if g.captured || len(g.args) != 0 {
+				gs = append(gs, g)
+			}
+			return false
+		}
+		return true
+	})
+	return gs
+}
+
+// assigns reports whether body assigns the variable obj, its fields or its elements, or takes its address.
+func assigns(body *ast.BlockStmt, obj *ast.Object) bool {
+	var result bool
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, e := range n.Lhs {
+				result = result || isVarOf(rootExpr(e), obj)
+			}
+		case *ast.IncDecStmt:
+			result = result || isVarOf(rootExpr(n.X), obj)
+		case *ast.UnaryExpr:
+			result = result || (n.Op == token.AND && isVarOf(rootExpr(n.X), obj))
+		}
+		return !result
+	})
+	return result
+}
+
+// declares reports whether the statements in the top of body declare name.
+func declares(body *ast.BlockStmt, name string) bool {
+	for _, s := range body.List {
+		switch s := s.(type) {
+		case *ast.AssignStmt:
+			if s.Tok != token.DEFINE {
+				continue
+			}
+			for _, e := range s.Lhs {
+				if idt, ok := e.(*ast.Ident); ok && idt.Name == name {
+					return true
+				}
+			}
+		case *ast.DeclStmt:
+			gd, ok := s.Decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, idt := range spec.Names {
+						if idt.Name == name {
+							return true
+						}
+					}
+				case *ast.TypeSpec:
+					if spec.Name.Name == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// rootExpr returns the variable of the selectors, the index expressions and the dereferences. ex: req of req.URL.Path
+func rootExpr(e ast.Expr) ast.Expr {
+	for {
+		switch x := e.(type) {
+		case *ast.SelectorExpr:
+			e = x.X
+		case *ast.IndexExpr:
+			e = x.X
+		case *ast.StarExpr:
+			e = x.X
+		case *ast.ParenExpr:
+			e = x.X
+		default:
+			return e
+		}
+	}
+}
+
+func isVarOf(e ast.Expr, obj *ast.Object) bool {
+	idt, ok := e.(*ast.Ident)
+	return ok && idt.Obj == obj
+}
+
+// isReqContext reports whether e is req.Context() of the request obj.
+func isReqContext(e ast.Expr, obj *ast.Object) bool {
+	ce, ok := e.(*ast.CallExpr)
+	if !ok || len(ce.Args) != 0 {
+		return false
+	}
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	return ok && se.Sel.Name == "Context" && isVarOf(se.X, obj)
+}
+
+// goroutineParamType returns the type of the variable of t if it is context.Context or *http.Request.
+func goroutineParamType(f *ast.File, t *funcTarget) string {
+	fd, ok := t.obj.Decl.(*ast.Field)
+	if !ok {
+		return ""
+	}
+	typ := gotypes.ExprString(fd.Type)
+	switch {
+	case t.typ == TypeContext && typ == getImportName(f.Imports, TypeContext)+".Context":
+		return typ
+	case t.typ == TypeHttpRequest && typ == "*"+getImportName(f.Imports, TypeHttpRequest)+".Request":
+		return typ
+	}
+	return ""
+}
+
+// propagateGoroutines passes the context which has the new goroutine of the transaction to the go statements in t.
+// The captured variable is passed as the parameter of the function literal, so its body is kept as it is.
+// The go statement is not rewritten if the function literal assigns the captured variable
+// or redeclares its name in the top of the body, goroutineIssues reports it.
+//
+//	go worker(newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine()))
+//	go func(ctx context.Context) {
+//		// do anything...
+//	}(newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine()))
+func propagateGoroutines(f *ast.File, t *funcTarget, pkg string) {
+	for _, g := range findGoroutines(f, t) {
+		if g.assigned || g.declared {
+			continue
+		}
+		pos := g.stmt.Call.Lparen
+		for _, i := range g.args {
+			g.stmt.Call.Args[i] = propagatedExpr(pkg, t, isVarOf(g.stmt.Call.Args[i], t.obj), pos)
+		}
+		if !g.captured {
+			continue
+		}
+		ft := g.stmt.Call.Fun.(*ast.FuncLit).Type
+		typ, err := parser.ParseExpr(goroutineParamType(f, t))
+		if err != nil {
+			continue
+		}
+		setPos(typ, ft.Params.Opening)
+		p := &ast.Field{Names: []*ast.Ident{{NamePos: ft.Params.Opening, Name: t.vn}}, Type: typ}
+		ft.Params.List = append([]*ast.Field{p}, ft.Params.List...)
+		args := []ast.Expr{propagatedExpr(pkg, t, true, pos)}
+		g.stmt.Call.Args = append(args, g.stmt.Call.Args...)
+	}
+}
+
+// propagatedExpr returns the expression of the context which has the new goroutine of the transaction.
+// If v is true, it returns the variable of t with the context. ex: req.WithContext(...)
+func propagatedExpr(pkg string, t *funcTarget, v bool, pos token.Pos) ast.Expr {
+	ctx := t.vn
+	if t.typ == TypeHttpRequest {
+		ctx += ".Context()"
+	}
+	src := fmt.Sprintf("%[1]s.NewContext(%[2]s, %[1]s.FromContext(%[2]s).NewGoroutine())", pkg, ctx)
+	if t.typ == TypeHttpRequest && v {
+		src = fmt.Sprintf("%s.WithContext(%s)", t.vn, src)
+	}
+	e, err := parser.ParseExpr(src)
+	if err != nil {
+		// vn is the variable name, so the expression is always valid.
+		panic(err)
+	}
+	setPos(e, pos)
+	return e
+}
+
+// revertGoroutines reverts the go statements in f which propagateGoroutines rewrote.
+// It reports whether any go statement is reverted.
+func revertGoroutines(f *ast.File, pkg string) bool {
+	var reverted bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		gs, ok := n.(*ast.GoStmt)
+		if !ok {
+			return true
+		}
+		args := gs.Call.Args
+		if fl, ok := gs.Call.Fun.(*ast.FuncLit); ok && len(args) != 0 && len(fl.Type.Params.List) != 0 {
+			// the parameter which is added for the captured variable.
+			p := fl.Type.Params.List[0]
+			if e := propagatedVar(pkg, args[0]); e != nil && len(p.Names) == 1 && gotypes.ExprString(rootExpr(e)) == p.Names[0].Name {
+				fl.Type.Params.List = fl.Type.Params.List[1:]
+				gs.Call.Args = args[1:]
+				reverted = true
+			}
+		}
+		for i, a := range gs.Call.Args {
+			if e := propagatedVar(pkg, a); e != nil {
+				gs.Call.Args[i] = e
+				reverted = true
+			}
+		}
+		return true
+	})
+	return reverted
+}
+
+// propagatedVar returns the original expression of e if e is built by propagatedExpr.
+// ex: ctx of newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine())
+func propagatedVar(pkg string, e ast.Expr) ast.Expr {
+	if ce, ok := e.(*ast.CallExpr); ok && len(ce.Args) == 1 {
+		// req.WithContext(newrelic.NewContext(req.Context(), ...))
+		if se, ok := ce.Fun.(*ast.SelectorExpr); ok && se.Sel.Name == "WithContext" {
+			if ctx := propagatedVar(pkg, ce.Args[0]); ctx != nil && gotypes.ExprString(ctx) == gotypes.ExprString(se.X)+".Context()" {
+				return se.X
+			}
+		}
+	}
+	ce, ok := e.(*ast.CallExpr)
+	if !ok || len(ce.Args) != 2 || gotypes.ExprString(ce.Fun) != pkg+".NewContext" {
+		return nil
+	}
+	ctx := gotypes.ExprString(ce.Args[0])
+	if gotypes.ExprString(ce.Args[1]) != fmt.Sprintf("%s.FromContext(%s).NewGoroutine()", pkg, ctx) {
+		return nil
+	}
+	return ce.Args[0]
+}
+
+// goroutineIssues returns the issues of the go statements in t which use the context without NewGoroutine.
+func goroutineIssues(fs *token.FileSet, f *ast.File, t *funcTarget) []Issue {
+	var result []Issue
+	for _, g := range findGoroutines(f, t) {
+		msg := "goroutine receives %s without NewGoroutine"
+		switch {
+		case g.assigned:
+			msg = "goroutine assigns %s captured without NewGoroutine, pass it by hand"
+		case g.declared:
+			msg = "goroutine redeclares %s captured without NewGoroutine, pass it by hand"
+		case g.captured:
+			msg = "goroutine captures %s without NewGoroutine"
+		}
+		fd := newFinding(fs, t, RuleGoroutine, msg, t.vn)
+		p := fs.Position(g.stmt.Pos())
+		fd.Line, fd.Column = p.Line, p.Column
+		result = append(result, Issue{Finding: fd, Pos: g.stmt.Pos(), Body: t.body})
+	}
+	return result
+}
@@ -0,0 +1,223 @@
+package nrseg
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const goroutineSrc = `package sample
+
+import (
+	"context"
+	"net/http"
+)
+
+func Spawn(ctx context.Context, ids []int) {
+	go worker(ctx, 1)
+	for _, id := range ids {
+		go func(n int) {
+			worker(ctx, n)
+		}(id)
+	}
+	go func() {
+		go worker(ctx, 2)
+	}()
+}
+
+func Handler(w http.ResponseWriter, req *http.Request) {
+	go handle(req)
+	go worker(req.Context(), 3)
+	go func() {
+		_ = req
+	}()
+}
+
+func Assign(ctx context.Context) {
+	go func(n int) {
+		ctx = context.Background()
+		worker(ctx, n)
+	}(1)
+}
+
+func Field(w http.ResponseWriter, req *http.Request) {
+	go func() {
+		req.Method = http.MethodGet
+	}()
+}
+
+func Shadow(ctx context.Context) {
+	go func() {
+		worker(ctx, 4)
+		ctx := context.Background()
+		worker(ctx, 5)
+	}()
+}
+
+func worker(ctx context.Context, n int) {}
+
+func handle(req *http.Request) {}
+`
+
+func TestProcessWithConfig_NewGoroutine(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{NewGoroutine: true}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	want := `package sample
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Spawn(ctx context.Context, ids []int) {
+	defer newrelic.FromContext(ctx).StartSegment("spawn").End()
+	go worker(newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine()), 1)
+	for _, id := range ids {
+		go func(ctx context.Context, n int) {
+			defer newrelic.FromContext(ctx).StartSegment("spawn_func1").End()
+			worker(ctx, n)
+		}(newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine()), id)
+	}
+	go func(ctx context.Context) {
+		defer newrelic.FromContext(ctx).StartSegment("spawn_func2").End()
+		go worker(newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine()), 2)
+	}(newrelic.NewContext(ctx, newrelic.FromContext(ctx).NewGoroutine()))
+}
+
+func Handler(w http.ResponseWriter, req *http.Request) {
+	defer newrelic.FromContext(req.Context()).StartSegment("handler").End()
+	go handle(req.WithContext(newrelic.NewContext(req.Context(), newrelic.FromContext(req.Context()).NewGoroutine())))
+	go worker(newrelic.NewContext(req.Context(), newrelic.FromContext(req.Context()).NewGoroutine()), 3)
+	go func(req *http.Request) {
+		defer newrelic.FromContext(req.Context()).StartSegment("handler_func1").End()
+		_ = req
+	}(req.WithContext(newrelic.NewContext(req.Context(), newrelic.FromContext(req.Context()).NewGoroutine())))
+}
+
+func Assign(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("assign").End()
+	go func(n int) {
+		defer newrelic.FromContext(ctx).StartSegment("assign_func1").End()
+		ctx = context.Background()
+		worker(ctx, n)
+	}(1)
+}
+
+func Field(w http.ResponseWriter, req *http.Request) {
+	defer newrelic.FromContext(req.Context()).StartSegment("field").End()
+	go func() {
+		defer newrelic.FromContext(req.Context()).StartSegment("field_func1").End()
+		req.Method = http.MethodGet
+	}()
+}
+
+func Shadow(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("shadow").End()
+	go func() {
+		defer newrelic.FromContext(ctx).StartSegment("shadow_func1").End()
+		worker(ctx, 4)
+		ctx := context.Background()
+		worker(ctx, 5)
+	}()
+}
+
+func worker(ctx context.Context, n int) {}
+
+func handle(req *http.Request) {}
+`
+	got, err := ProcessWithConfig("sample.go", []byte(goroutineSrc), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+	// the propagated contexts are not wrapped twice.
+	got, err = ProcessWithConfig("sample.go", got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("second run -got +want %v", diff)
+	}
+}
+
+func TestCheck_NewGoroutine(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{NewGoroutine: true}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, "sample.go", goroutineSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := Check(fs, f, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		Line    int
+		Label   string
+		Message string
+	}
+	var got []result
+	for _, i := range is {
+		if i.Rule == RuleGoroutine {
+			got = append(got, result{Line: i.Line, Label: i.Label, Message: i.Message})
+		}
+	}
+	want := []result{
+		{Line: 9, Label: "Spawn", Message: "goroutine receives ctx without NewGoroutine"},
+		{Line: 11, Label: "Spawn", Message: "goroutine captures ctx without NewGoroutine"},
+		{Line: 15, Label: "Spawn", Message: "goroutine captures ctx without NewGoroutine"},
+		{Line: 16, Label: "Spawn.func2", Message: "goroutine receives ctx without NewGoroutine"},
+		{Line: 21, Label: "Handler", Message: "goroutine receives req without NewGoroutine"},
+		{Line: 22, Label: "Handler", Message: "goroutine receives req without NewGoroutine"},
+		{Line: 23, Label: "Handler", Message: "goroutine captures req without NewGoroutine"},
+		{Line: 29, Label: "Assign", Message: "goroutine assigns ctx captured without NewGoroutine, pass it by hand"},
+		{Line: 36, Label: "Field", Message: "goroutine assigns req captured without NewGoroutine, pass it by hand"},
+		{Line: 42, Label: "Shadow", Message: "goroutine redeclares ctx captured without NewGoroutine, pass it by hand"},
+	}
+	if diff := cmp.Diff(got, want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	// the goroutines are not checked without the option.
+	is, err = Check(fs, f, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range is {
+		if i.Rule == RuleGoroutine {
+			t.Errorf("Check() reports %v without NewGoroutine", i.Finding)
+		}
+	}
+}
+
+func TestRemove_NewGoroutine_RoundTrip(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{NewGoroutine: true}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ProcessWithConfig("sample.go", []byte(goroutineSrc), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = RemoveWithConfig("sample.go", got, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), goroutineSrc); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
@@ -0,0 +1,466 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	gotypes "go/types"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+var errWrapHandlers = errors.New("wrap-handlers supports only newrelic backend")
+
+// Unwrapped is the HTTP handler registration which wrap-handlers subcommand could not rewrite.
+type Unwrapped struct {
+	File string
+	Line int
+	// Call is the function which registers the handler. ex: mux.HandleFunc
+	Call   string
+	Reason string
+}
+
+// WrapHandlers wraps the HTTP handler registrations in src by newrelic.WrapHandle or newrelic.WrapHandleFunc.
+func WrapHandlers(filename string, src []byte, app string) ([]byte, []Unwrapped, error) {
+	return WrapHandlersWithConfig(filename, src, nil, app)
+}
+
+// WrapHandlersWithConfig wraps the HTTP handler registrations in src by newrelic.WrapHandle or newrelic.WrapHandleFunc.
+// ex:
+//
+//	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/users", users))
+//
+// app is the expression of *newrelic.Application. If it is empty, the variable is searched from
+// the enclosing functions, the fields of the receiver and the package variables in the file.
+// The registrations which could not be rewritten are returned as Unwrapped.
+// Only the arguments of the registrations are rewritten, the other code is kept as it is.
+// The method expression of *http.ServeMux is rewritten to the method call.
+func WrapHandlersWithConfig(filename string, src []byte, cfg *Config, app string) ([]byte, []Unwrapped, error) {
+	if _, ok := cfg.instrumenter().(newrelicInstrumenter); !ok {
+		return nil, nil, errWrapHandlers
+	}
+	if len(src) != 0 && c.Match(src) {
+		return src, nil, nil
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.ignoredFile(fs, f) {
+		return src, nil, nil
+	}
+	pkg := "newrelic"
+	name, err := findImport(f, NewRelicV3Pkg)
+	imported := !errors.Is(err, ErrNoImportNrPkg)
+	switch {
+	case imported && err != nil:
+		return nil, nil, err
+	case len(name) != 0:
+		pkg = name
+	case !imported && len(cfg.importAlias()) != 0:
+		pkg = cfg.importAlias()
+	}
+
+	isMux := cfg.muxes(fs, f)
+
+	// edit replaces src[pos:end] with text.
+	type edit struct {
+		pos, end int
+		text     string
+	}
+	var edits []edit
+	var us []Unwrapped
+	var stack []ast.Node
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		ce, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		args, wrapper, ok := registration(ce)
+		if !ok {
+			return true
+		}
+		unwrap := func(reason string) bool {
+			p := fs.Position(ce.Pos())
+			us = append(us, Unwrapped{File: p.Filename, Line: p.Line, Call: gotypes.ExprString(ce.Fun), Reason: reason})
+			return true
+		}
+		se := ce.Fun.(*ast.SelectorExpr)
+		if !isMux(se.X, stack) {
+			return unwrap("the receiver is not net/http or *http.ServeMux")
+		}
+		a := app
+		if len(a) == 0 {
+			var reason string
+			if a, reason = findApp(f, pkg, stack); len(reason) != 0 {
+				return unwrap(reason)
+			}
+		}
+		start := fs.Position(args[len(args)-2].Pos()).Offset
+		pos := start
+		text := fmt.Sprintf("%s.%s(%s, ", pkg, wrapper, a)
+		if len(args) == 3 {
+			// the method expression is rewritten to the method call because it can not take the wrapped handler.
+			// ex: (*http.ServeMux).HandleFunc(mux, pattern, h) -> mux.HandleFunc(newrelic.WrapHandleFunc(app, pattern, h))
+			recv := string(src[fs.Position(args[0].Pos()).Offset:fs.Position(args[0].End()).Offset])
+			switch args[0].(type) {
+			case *ast.StarExpr, *ast.UnaryExpr, *ast.BinaryExpr:
+				recv = "(" + recv + ")"
+			}
+			pos = fs.Position(ce.Pos()).Offset
+			text = fmt.Sprintf("%s.%s(%s", recv, se.Sel.Name, text)
+		}
+		edits = append(edits,
+			edit{pos: pos, end: start, text: text},
+			edit{pos: fs.Position(args[len(args)-1].End()).Offset, end: fs.Position(args[len(args)-1].End()).Offset, text: ")"},
+		)
+		return true
+	})
+	if len(edits) == 0 {
+		return src, us, nil
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].pos < edits[j].pos })
+	var buf bytes.Buffer
+	var last int
+	for _, e := range edits {
+		buf.Write(src[last:e.pos])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(src[last:])
+	if imported {
+		return buf.Bytes(), us, nil
+	}
+
+	fs = token.NewFileSet()
+	f, err = parser.ParseFile(fs, filename, buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	astutil.AddNamedImport(fs, f, cfg.importAlias(), NewRelicV3Pkg)
+	var out bytes.Buffer
+	if err := format.Node(&out, fs, f); err != nil {
+		return nil, nil, err
+	}
+	got, err := imports.Process(filename, out.Bytes(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return got, us, nil
+}
+
+// registration returns the arguments of ce if ce calls Handle or HandleFunc like the HTTP handler registration,
+// and the wrapper of the handler.
+// ex: http.HandleFunc(pattern, h), mux.Handle(pattern, h)
+// The method expression returns the receiver too. ex: (*http.ServeMux).HandleFunc(mux, pattern, h)
+// The registration which is wrapped already has only one argument, so it is not returned.
+func registration(ce *ast.CallExpr) ([]ast.Expr, string, bool) {
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil, "", false
+	}
+	var wrapper string
+	switch se.Sel.Name {
+	case "Handle":
+		wrapper = "WrapHandle"
+	case "HandleFunc":
+		wrapper = "WrapHandleFunc"
+	default:
+		return nil, "", false
+	}
+	n := 2
+	if _, ok := se.X.(*ast.ParenExpr); ok && len(ce.Args) == 3 {
+		// the method expression takes the receiver as the first argument.
+		n = 3
+	}
+	if len(ce.Args) != n || ce.Ellipsis.IsValid() {
+		return nil, "", false
+	}
+	return ce.Args, wrapper, true
+}
+
+// muxFunc reports whether the receiver of Handle or HandleFunc is the net/http package or *http.ServeMux.
+// stack is the nodes which enclose the receiver.
+type muxFunc func(recv ast.Expr, stack []ast.Node) bool
+
+// muxes returns the function which detects the receiver of the HTTP handler registration in the file.
+// The type information is used if it is loaded by LoadTypes.
+func (cfg *Config) muxes(fs *token.FileSet, f *ast.File) muxFunc {
+	if cfg != nil && cfg.typed != nil {
+		if fn, err := filepath.Abs(fs.File(f.Pos()).Name()); err == nil {
+			if tf, ok := cfg.typed[fn]; ok {
+				return func(recv ast.Expr, _ []ast.Node) bool {
+					return tf.muxes[fs.Position(recv.Pos()).Offset]
+				}
+			}
+		}
+	}
+	name, err := findImport(f, "net/http")
+	if err != nil {
+		// *http.ServeMux can not be declared without net/http.
+		return func(ast.Expr, []ast.Node) bool { return false }
+	}
+	if len(name) == 0 {
+		name = "http"
+	}
+	return func(recv ast.Expr, stack []ast.Node) bool {
+		return syntacticMux(f, name, recv, stack)
+	}
+}
+
+// syntacticMux reports whether recv is the net/http package, the variable of *http.ServeMux
+// or the type of the method expression.
+// The variable is detected by its declaration. ex: mux := http.NewServeMux(), mux *http.ServeMux, s.mux
+func syntacticMux(f *ast.File, pkg string, recv ast.Expr, stack []ast.Node) bool {
+	switch x := recv.(type) {
+	case *ast.Ident:
+		if x.Obj == nil {
+			// the package name is not resolved by the parser.
+			return x.Name == pkg
+		}
+		switch d := x.Obj.Decl.(type) {
+		case *ast.Field:
+			return isMuxType(pkg, d.Type)
+		case *ast.ValueSpec:
+			if d.Type != nil {
+				return isMuxType(pkg, d.Type)
+			}
+			for i, n := range d.Names {
+				if n.Obj == x.Obj && i < len(d.Values) {
+					return isNewMux(pkg, d.Values[i])
+				}
+			}
+		case *ast.AssignStmt:
+			if len(d.Lhs) != len(d.Rhs) {
+				return false
+			}
+			for i, e := range d.Lhs {
+				if idt, ok := e.(*ast.Ident); ok && idt.Obj == x.Obj {
+					return isNewMux(pkg, d.Rhs[i])
+				}
+			}
+		}
+	case *ast.ParenExpr:
+		// the method expression. ex: (*http.ServeMux).HandleFunc
+		return isMuxType(pkg, x.X)
+	case *ast.SelectorExpr:
+		idt, ok := x.X.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		if idt.Obj == nil {
+			return idt.Name == pkg && x.Sel.Name == "DefaultServeMux"
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			if fd, ok := stack[i].(*ast.FuncDecl); ok {
+				if ft := fieldType(f, fd, idt, x.Sel.Name); ft != nil {
+					return isMuxType(pkg, ft)
+				}
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// isMuxType reports whether e is *http.ServeMux.
+func isMuxType(pkg string, e ast.Expr) bool {
+	return gotypes.ExprString(e) == "*"+pkg+".ServeMux"
+}
+
+// isNewMux reports whether e creates *http.ServeMux. ex: http.NewServeMux(), &http.ServeMux{}
+func isNewMux(pkg string, e ast.Expr) bool {
+	switch x := e.(type) {
+	case *ast.CallExpr:
+		return gotypes.ExprString(x.Fun) == pkg+".NewServeMux"
+	case *ast.UnaryExpr:
+		cl, ok := x.X.(*ast.CompositeLit)
+		return ok && x.Op == token.AND && gotypes.ExprString(cl.Type) == pkg+".ServeMux"
+	}
+	return false
+}
+
+// fieldType returns the type of the field name if recv is the receiver of fd.
+// Only the struct declared in the file is searched.
+func fieldType(f *ast.File, fd *ast.FuncDecl, recv *ast.Ident, name string) ast.Expr {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 || len(fd.Recv.List[0].Names) == 0 || fd.Recv.List[0].Names[0].Obj != recv.Obj {
+		return nil
+	}
+	st := recvStruct(f, fd)
+	if st == nil {
+		return nil
+	}
+	for _, fl := range st.Fields.List {
+		for _, n := range fl.Names {
+			if n.Name == name {
+				return fl.Type
+			}
+		}
+	}
+	return nil
+}
+
+// recvStruct returns the struct type of the receiver of fd which is declared in the file.
+func recvStruct(f *ast.File, fd *ast.FuncDecl) *ast.StructType {
+	tn := recvName(fd)
+	if len(tn) == 0 {
+		return nil
+	}
+	var st *ast.StructType
+	ast.Inspect(f, func(n ast.Node) bool {
+		ts, ok := n.(*ast.TypeSpec)
+		if !ok || ts.Name.Name != tn {
+			return st == nil
+		}
+		st, _ = ts.Type.(*ast.StructType)
+		return false
+	})
+	return st
+}
+
+// findApp returns the expression of *newrelic.Application which can be used at the top of stack.
+// The nearest scope is used, and the reason is returned if it is not found or ambiguous.
+func findApp(f *ast.File, pkg string, stack []ast.Node) (string, string) {
+	pos := stack[len(stack)-1].Pos()
+	for i := len(stack) - 1; i >= 0; i-- {
+		var apps []string
+		switch n := stack[i].(type) {
+		case *ast.FuncLit:
+			apps = append(paramApps(pkg, n.Type), localApps(pkg, n.Body, pos)...)
+		case *ast.FuncDecl:
+			apps = append(paramApps(pkg, n.Type), localApps(pkg, n.Body, pos)...)
+			if len(apps) == 0 {
+				apps = fieldApps(f, pkg, n)
+			}
+		case *ast.File:
+			apps = packageApps(pkg, n)
+		}
+		switch {
+		case len(apps) == 1:
+			return apps[0], ""
+		case len(apps) > 1:
+			return "", fmt.Sprintf("several *%s.Application are found: %v, set -app", pkg, apps)
+		}
+	}
+	return "", fmt.Sprintf("*%s.Application is not found, set -app", pkg)
+}
+
+// isAppType reports whether e is *newrelic.Application.
+func isAppType(pkg string, e ast.Expr) bool {
+	return gotypes.ExprString(e) == "*"+pkg+".Application"
+}
+
+// isNewApp reports whether e is the call of newrelic.NewApplication.
+func isNewApp(pkg string, e ast.Expr) bool {
+	ce, ok := e.(*ast.CallExpr)
+	return ok && gotypes.ExprString(ce.Fun) == pkg+".NewApplication"
+}
+
+func paramApps(pkg string, ft *ast.FuncType) []string {
+	var apps []string
+	for _, p := range ft.Params.List {
+		if isAppType(pkg, p.Type) {
+			for _, n := range p.Names {
+				apps = append(apps, n.Name)
+			}
+		}
+	}
+	return apps
+}
+
+// localApps returns the variables of *newrelic.Application which are declared before pos in body.
+// The function literals in body are not searched, they are the other scopes.
+func localApps(pkg string, body *ast.BlockStmt, pos token.Pos) []string {
+	var apps []string
+	ast.Inspect(body, func(n ast.Node) bool {
+		if n == nil || n.Pos() >= pos {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE && len(n.Rhs) == 1 && isNewApp(pkg, n.Rhs[0]) {
+				if idt, ok := n.Lhs[0].(*ast.Ident); ok && idt.Name != "_" {
+					apps = append(apps, idt.Name)
+				}
+			}
+		case *ast.ValueSpec:
+			apps = append(apps, specApps(pkg, n)...)
+		}
+		return true
+	})
+	return apps
+}
+
+func specApps(pkg string, vs *ast.ValueSpec) []string {
+	var apps []string
+	if (vs.Type != nil && isAppType(pkg, vs.Type)) || (len(vs.Values) == 1 && isNewApp(pkg, vs.Values[0])) {
+		if len(vs.Names) != 0 && vs.Names[0].Name != "_" {
+			apps = append(apps, vs.Names[0].Name)
+		}
+	}
+	return apps
+}
+
+// fieldApps returns the fields of *newrelic.Application of the receiver of fd.
+// Only the struct declared in the file is searched.
+func fieldApps(f *ast.File, pkg string, fd *ast.FuncDecl) []string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 || len(fd.Recv.List[0].Names) == 0 {
+		return nil
+	}
+	st := recvStruct(f, fd)
+	if st == nil {
+		return nil
+	}
+	recv := fd.Recv.List[0].Names[0].Name
+	var apps []string
+	for _, fl := range st.Fields.List {
+		if !isAppType(pkg, fl.Type) {
+			continue
+		}
+		if len(fl.Names) == 0 {
+			// the embedded field.
+			apps = append(apps, recv+".Application")
+		}
+		for _, n := range fl.Names {
+			apps = append(apps, recv+"."+n.Name)
+		}
+	}
+	return apps
+}
+
+func packageApps(pkg string, f *ast.File) []string {
+	var apps []string
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		for _, s := range gd.Specs {
+			apps = append(apps, specApps(pkg, s.(*ast.ValueSpec))...)
+		}
+	}
+	return apps
+}
+
+// writeUnwrapped writes the registrations which could not be rewritten.
+func writeUnwrapped(w io.Writer, us []Unwrapped) {
+	for _, u := range us {
+		fmt.Fprintf(w, "%s:%d: %s is not wrapped: %s\n", u.File, u.Line, u.Call, u.Reason)
+	}
+}
@@ -0,0 +1,381 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWrapHandlers(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name   string
+		src    string
+		app    string
+		want   string
+		wantUs []Unwrapped
+	}{
+		{
+			name: "local",
+			src: `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	app, _ := newrelic.NewApplication()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", users)
+	mux.Handle("/static",
+		http.FileServer(http.Dir(".")))
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/wrapped", users))
+	(*http.ServeMux).HandleFunc(mux, "/expr", users)
+	(*http.ServeMux).Handle(&s.mux, "/ptr", http.NotFoundHandler())
+	(*router).HandleFunc(r, "/router", users)
+}
+`,
+			want: `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	app, _ := newrelic.NewApplication()
+	mux := http.NewServeMux()
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/users", users))
+	mux.Handle(newrelic.WrapHandle(app, "/static",
+		http.FileServer(http.Dir("."))))
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/wrapped", users))
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/expr", users))
+	(&s.mux).Handle(newrelic.WrapHandle(app, "/ptr", http.NotFoundHandler()))
+	(*router).HandleFunc(r, "/router", users)
+}
+`,
+			wantUs: []Unwrapped{
+				{File: "main.go", Line: 18, Call: "(*router).HandleFunc", Reason: "the receiver is not net/http or *http.ServeMux"},
+			},
+		},
+		{
+			name: "scopes",
+			src: `package main
+
+import (
+	"net/http"
+
+	nr "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var global *nr.Application
+
+type server struct {
+	nrApp *nr.Application
+	mux   *http.ServeMux
+}
+
+func (s *server) routes() {
+	s.mux.HandleFunc("/field", users)
+}
+
+func register(mux *http.ServeMux, a *nr.Application) {
+	mux.HandleFunc("/param", users)
+}
+
+func init() {
+	http.HandleFunc("/global", users)
+}
+`,
+			want: `package main
+
+import (
+	"net/http"
+
+	nr "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var global *nr.Application
+
+type server struct {
+	nrApp *nr.Application
+	mux   *http.ServeMux
+}
+
+func (s *server) routes() {
+	s.mux.HandleFunc(nr.WrapHandleFunc(s.nrApp, "/field", users))
+}
+
+func register(mux *http.ServeMux, a *nr.Application) {
+	mux.HandleFunc(nr.WrapHandleFunc(a, "/param", users))
+}
+
+func init() {
+	http.HandleFunc(nr.WrapHandleFunc(global, "/global", users))
+}
+`,
+		},
+		{
+			name: "unwrapped",
+			src: `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	http.HandleFunc("/none", users)
+}
+
+func both(a, b *newrelic.Application) {
+	http.HandleFunc("/both", users)
+}
+`,
+			want: `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	http.HandleFunc("/none", users)
+}
+
+func both(a, b *newrelic.Application) {
+	http.HandleFunc("/both", users)
+}
+`,
+			wantUs: []Unwrapped{
+				{File: "main.go", Line: 10, Call: "http.HandleFunc", Reason: "*newrelic.Application is not found, set -app"},
+				{File: "main.go", Line: 14, Call: "http.HandleFunc", Reason: "several *newrelic.Application are found: [a b], set -app"},
+			},
+		},
+		{
+			name: "receivers",
+			src: `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type bus struct{}
+
+func (bus) Handle(topic string, fn func()) {}
+
+func main() {
+	app, _ := newrelic.NewApplication()
+	var b bus
+	b.Handle("topic", func() {})
+	mux := &http.ServeMux{}
+	mux.Handle("/mux", http.NotFoundHandler())
+	http.DefaultServeMux.HandleFunc("/default", users)
+	http := b
+	http.Handle("/shadow", nil)
+}
+`,
+			want: `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type bus struct{}
+
+func (bus) Handle(topic string, fn func()) {}
+
+func main() {
+	app, _ := newrelic.NewApplication()
+	var b bus
+	b.Handle("topic", func() {})
+	mux := &http.ServeMux{}
+	mux.Handle(newrelic.WrapHandle(app, "/mux", http.NotFoundHandler()))
+	http.DefaultServeMux.HandleFunc(newrelic.WrapHandleFunc(app, "/default", users))
+	http := b
+	http.Handle("/shadow", nil)
+}
+`,
+			wantUs: []Unwrapped{
+				{File: "main.go", Line: 16, Call: "b.Handle", Reason: "the receiver is not net/http or *http.ServeMux"},
+				{File: "main.go", Line: 21, Call: "http.Handle", Reason: "the receiver is not net/http or *http.ServeMux"},
+			},
+		},
+		{
+			name: "app",
+			src: `package main
+
+import (
+	"net/http"
+	"os"
+)
+
+func main() {
+	http.HandleFunc("/users", users)
+	os.Exit(0)
+}
+`,
+			app: "cfg.App",
+			want: `package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func main() {
+	http.HandleFunc(newrelic.WrapHandleFunc(cfg.App, "/users", users))
+	os.Exit(0)
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, us, err := WrapHandlers("main.go", []byte(tt.src), tt.app)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); diff != "" {
+				t.Errorf("WrapHandlers() -got +want %v", diff)
+			}
+			if diff := cmp.Diff(us, tt.wantUs); diff != "" {
+				t.Errorf("WrapHandlers() unwrapped -got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestWrapHandlersWithConfig_Types(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	src := `package main
+
+import "net/http"
+
+type router interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+func newMux() *http.ServeMux { return http.NewServeMux() }
+
+func register(r router) {
+	mux := newMux()
+	mux.HandleFunc("/typed", users)
+	r.HandleFunc("/router", users)
+	(*http.ServeMux).HandleFunc(mux, "/expr", users)
+}
+
+func users(w http.ResponseWriter, req *http.Request) {}
+`
+	for name, body := range map[string]string{"go.mod": "module example.com/mux\n\ngo 1.21\n", "main.go": src} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.LoadTypes(dir); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "main.go")
+	got, us, err := WrapHandlersWithConfig(path, []byte(src), cfg, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type router interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+func newMux() *http.ServeMux { return http.NewServeMux() }
+
+func register(r router) {
+	mux := newMux()
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/typed", users))
+	r.HandleFunc("/router", users)
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/expr", users))
+}
+
+func users(w http.ResponseWriter, req *http.Request) {}
+`
+	if diff := cmp.Diff(string(got), want); diff != "" {
+		t.Errorf("WrapHandlersWithConfig() -got +want %v", diff)
+	}
+	wantUs := []Unwrapped{
+		{File: path, Line: 14, Call: "r.HandleFunc", Reason: "the receiver is not net/http or *http.ServeMux"},
+	}
+	if diff := cmp.Diff(us, wantUs); diff != "" {
+		t.Errorf("WrapHandlersWithConfig() unwrapped -got +want %v", diff)
+	}
+}
+
+func TestWrapHandlersWithConfig_Backend(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{Backend: BackendOpenTelemetry}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := WrapHandlersWithConfig("main.go", []byte("package main\n"), cfg, ""); !errors.Is(err, errWrapHandlers) {
+		t.Errorf("want %v, but got %v", errWrapHandlers, err)
+	}
+}
+
+func TestNrseg_Run_WrapHandlers(t *testing.T) {
+	t.Parallel()
+	dir := configDir(t)
+	src := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/users", users)
+}
+`
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	if err := Run([]string{"nrseg", "wrap-handlers", dir}, out, errs, "", ""); !errors.Is(err, ErrFlagTrue) {
+		t.Fatalf("want %v, but got %v", ErrFlagTrue, err)
+	}
+	want := path + ":6: http.HandleFunc is not wrapped: *newrelic.Application is not found, set -app\n"
+	if errs.String() != want {
+		t.Errorf("want\n%s\nbut got\n%s", want, errs.String())
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != src {
+		t.Errorf("the file is rewritten\n%s", got)
+	}
+}
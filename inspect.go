@@ -1,50 +1,27 @@
 package nrseg
 
 import (
-	"errors"
-	"go/ast"
 	"go/parser"
 	"go/token"
 )
 
-func (nrseg *nrseg) Inspect(filename string, src []byte) error {
+// Inspect returns the findings in src, and the functions which are inspected as the keys of the baseline entries.
+func (nrseg *nrseg) Inspect(filename string, src []byte) ([]Finding, []baselineEntry, error) {
 	if len(src) != 0 && c.Match(src) {
-		return nil
+		return nil, nil, nil
 	}
 	fs := token.NewFileSet()
 	f, err := parser.ParseFile(fs, filename, src, parser.ParseComments)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	// import newrelic pkg
-	pkg := "newrelic"
-	name, err := findImport(fs, f) // importされたpkgの名前
-	if err != nil && !errors.Is(err, ErrNoImportNrPkg) {
-		return err
+	is, err := Check(fs, f, nrseg.cfg, nrseg.checkNames)
+	if err != nil {
+		return nil, nil, err
 	}
-	if len(name) != 0 {
-		// change name if named import.
-		pkg = name
+	fds := make([]Finding, 0, len(is))
+	for _, i := range is {
+		fds = append(fds, i.Finding)
 	}
-
-	ast.Inspect(f, func(n ast.Node) bool {
-		if fd, ok := n.(*ast.FuncDecl); ok {
-			if findIgnoreComment(fd.Doc) {
-				return false
-			}
-			if fd.Body != nil && len(fd.Body.List) > 0 {
-				if _, t := parseParams(f.Imports, fd.Type); !(t == TypeContext || t == TypeHttpRequest) {
-					return false
-				}
-				if !existFromContext(pkg, fd.Body.List[0]) {
-					nrseg.errFlag = true
-					nrseg.reportf(filename, fs, fd.Pos(), fd)
-				}
-				return false
-			}
-		}
-		return true
-	})
-
-	return nil
+	return fds, inspectedFuncs(fs, f, nrseg.cfg), nil
 }
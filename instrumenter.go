@@ -0,0 +1,201 @@
+package nrseg
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+)
+
+// Instrumentation backends.
+const (
+	// BackendNewRelic inserts New Relic function segments. It is the default backend.
+	BackendNewRelic = "newrelic"
+	// BackendOpenTelemetry inserts OpenTelemetry spans.
+	BackendOpenTelemetry = "otel"
+)
+
+// Instrumenter builds and detects the statements inserted into functions.
+// Set Config.Instrumenter to use the custom implementation with ProcessWithConfig.
+type Instrumenter interface {
+	// ImportPath returns the import path of the package which the statements use.
+	ImportPath() string
+	// PackageName returns the package name of ImportPath.
+	PackageName() string
+	// Build returns the statements inserted at the top of the function body.
+	Build(t *Target) []ast.Stmt
+	// Instrumented reports whether the function body which starts with s is instrumented already.
+	// pkg is the package name of ImportPath in the file.
+	Instrumented(pkg string, s ast.Stmt) bool
+}
+
+// SegmentNamer is implemented by the Instrumenter which can read the segment name.
+// It is used by inspect -names.
+type SegmentNamer interface {
+	// SegmentName returns the segment name in s.
+	SegmentName(pkg string, s ast.Stmt) (string, bool)
+}
+
+// Remover is implemented by the Instrumenter which supports remove subcommand.
+type Remover interface {
+	// Generated returns the number of the statements from the head of ss which were built by Build.
+	// If all is true, it counts the hand-written instrumentation too.
+	Generated(pkg string, ss []ast.Stmt, all bool) int
+}
+
+// Target is the function which is instrumented.
+type Target struct {
+	// Pkg is the package name of Instrumenter.ImportPath in the file. ex: newrelic, nr
+	Pkg string
+	// Var is the variable name which has context.Context or *http.Request.
+	Var string
+	// Type is the type of Var. TypeContext, TypeHttpRequest or the type of ContextType. ex: *gin.Context
+	Type string
+	// Captured is true if Var is captured from the enclosing function.
+	Captured bool
+	// Ctx is the expression which returns context.Context. ex: ctx, req.Context()
+	Ctx string
+	// Name is the segment name.
+	Name string
+	// NameData is the source of Name.
+	*NameData
+	// Pos is the position of the inserted statements.
+	Pos token.Pos
+	// Line is the line of the function.
+	Line int
+
+	// funcType and body are the function which is instrumented.
+	funcType *ast.FuncType
+	body     *ast.BlockStmt
+}
+
+// CtxExpr returns Ctx as ast.Expr.
+func (t *Target) CtxExpr() ast.Expr {
+	e, err := parser.ParseExpr(t.Ctx)
+	if err != nil {
+		return &ast.Ident{NamePos: t.Pos, Name: t.Ctx}
+	}
+	setPos(e, t.Pos)
+	return e
+}
+
+// FreeName returns the variable name which can be declared by the inserted statements.
+// If name is used in the function, alt, alt2, alt3... are tried in order.
+func (t *Target) FreeName(name, alt string) string {
+	if t.funcType == nil || t.body == nil {
+		return name
+	}
+	return freeName(t.funcType, t.body, name, alt)
+}
+
+func (t *funcTarget) target(pkg string) *Target {
+	return &Target{
+		Pkg:      pkg,
+		Var:      t.vn,
+		Type:     t.typ,
+		Captured: t.captured,
+		Ctx:      t.ctx,
+		Name:     t.name,
+		NameData: t.nd,
+		Pos:      t.body.Lbrace,
+		Line:     t.line,
+		funcType: funcTypeOf(t.node),
+		body:     t.body,
+	}
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+// setPos overwrites all valid positions in n with pos.
+// The nodes parsed from the other source are able to be inserted to the file with it.
+func setPos(n ast.Node, pos token.Pos) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			return true
+		}
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType && f.Int() != int64(token.NoPos) {
+				f.SetInt(int64(pos))
+			}
+		}
+		return true
+	})
+}
+
+func newInstrumenter(backend string, codeLevelMetrics bool) (Instrumenter, error) {
+	switch backend {
+	case "", BackendNewRelic:
+		return newrelicInstrumenter{codeLevelMetrics: codeLevelMetrics}, nil
+	case BackendOpenTelemetry:
+		if codeLevelMetrics {
+			return nil, errCodeLevelMetrics
+		}
+		return otelInstrumenter{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", backend)
+}
+
+var errCodeLevelMetrics = errors.New("code_level_metrics is supported only by newrelic backend")
+
+type newrelicInstrumenter struct {
+	// codeLevelMetrics adds the code-level metrics attributes to the segment.
+	codeLevelMetrics bool
+}
+
+func (newrelicInstrumenter) ImportPath() string { return NewRelicV3Pkg }
+
+func (newrelicInstrumenter) PackageName() string { return "newrelic" }
+
+func (ni newrelicInstrumenter) Build(t *Target) []ast.Stmt {
+	var ds *ast.DeferStmt
+	switch t.Type {
+	case TypeContext:
+		ds = buildDeferStmt(t.Pos, t.Pkg, t.Var, t.Name)
+	case TypeHttpRequest:
+		ds = buildDeferStmtWithHttpRequest(t.Pos, t.Pkg, t.Var, t.Name)
+	default:
+		ds = skeletonDeferStmt(t.Pos, t.CtxExpr(), t.Pkg, t.Name)
+	}
+	if ni.codeLevelMetrics {
+		// reuse the segment start of the chain. ex: newrelic.FromContext(ctx).StartSegment("sample_func")
+		return buildCodeLevelStmts(t, ds.Call.Fun.(*ast.SelectorExpr).X)
+	}
+	return []ast.Stmt{ds}
+}
+
+func (newrelicInstrumenter) Instrumented(pkg string, s ast.Stmt) bool {
+	return existFromContext(pkg, s)
+}
+
+func (newrelicInstrumenter) SegmentName(_ string, s ast.Stmt) (string, bool) {
+	return findSegName(s)
+}
+
+func (newrelicInstrumenter) Generated(pkg string, ss []ast.Stmt, all bool) int {
+	n := segmentStmts(pkg, ss, all)
+	// the closure which notices the error follows the segment.
+	if n > 0 && n < len(ss) && isNoticeError(pkg, ss[n]) {
+		n++
+	}
+	return n
+}
+
+func segmentStmts(pkg string, ss []ast.Stmt, all bool) int {
+	if isGeneratedSegment(pkg, ss[0]) || (all && isSegment(ss[0])) {
+		return 1
+	}
+	if n := segmentVarStmts(ss, func(ce *ast.CallExpr) bool { return isGeneratedStart(pkg, ce) }, all); n > 0 {
+		return n
+	}
+	if all {
+		return segmentVarStmts(ss, isStartSegment, all)
+	}
+	return 0
+}
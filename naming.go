@@ -0,0 +1,203 @@
+package nrseg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Built-in naming styles of the segment name.
+const (
+	// NamingSnake is the default naming style. ex: user_repo_find_by_id
+	NamingSnake = "snake"
+	// NamingCamel ex: userRepoFindByID
+	NamingCamel = "camel"
+	// NamingDotted ex: repo.UserRepo.FindByID
+	NamingDotted = "dotted"
+	// NamingSlash ex: UserRepo/FindByID
+	NamingSlash = "slash"
+)
+
+// NameData is the data to build the segment name with the naming template.
+// ex: {{.Package}}.{{.Receiver}}.{{.Function}}
+type NameData struct {
+	// Package is the package name. ex: repo
+	Package string
+	// ImportPath is the import path of the package. It is empty if go.mod is not found.
+	ImportPath string
+	// Receiver is the receiver type name. ex: UserRepo
+	Receiver string
+	// Function is the function/method name. ex: FindByID
+	Function string
+	// Literal is the name of the function literal. ex: func1, users_id
+	Literal string
+	// File is the file name. ex: user_repo.go
+	File string
+	// Route is the route pattern which is registered with the function literal. ex: /users/{id}
+	Route string
+
+	parts []string
+	// modulePath is the file path relative to the module root. ex: repo/user_repo.go
+	modulePath string
+}
+
+var namingFuncs = template.FuncMap{
+	"snake": toSnake,
+	"camel": func(s string) string { return toCamel([]string{s}) },
+	"lower": strings.ToLower,
+}
+
+func parseNaming(naming string) (*template.Template, error) {
+	switch naming {
+	case "", NamingSnake, NamingCamel, NamingDotted, NamingSlash:
+		return nil, nil
+	}
+	if !strings.Contains(naming, "{{") {
+		return nil, fmt.Errorf("unknown naming style %q", naming)
+	}
+	tmpl, err := template.New("naming").Funcs(namingFuncs).Parse(naming)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(ioutil.Discard, &NameData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// segName builds the segment name from nd.
+func (cfg *Config) segName(nd *NameData) string {
+	var naming string
+	if cfg != nil {
+		naming = cfg.Naming
+	}
+	switch naming {
+	case NamingCamel:
+		return toCamel(nd.parts)
+	case NamingDotted:
+		return strings.Join(append([]string{nd.Package}, nd.parts...), ".")
+	case NamingSlash:
+		return strings.Join(nd.parts, "/")
+	case "", NamingSnake:
+	default:
+		var sb strings.Builder
+		if err := cfg.namer.Execute(&sb, nd); err == nil {
+			return sb.String()
+		}
+	}
+	ss := make([]string, 0, len(nd.parts))
+	for _, p := range nd.parts {
+		ss = append(ss, toSnake(p))
+	}
+	return strings.Join(ss, "_")
+}
+
+// child returns the name data of the function literal in nd.
+func (nd *NameData) child(sfx, route string) *NameData {
+	c := *nd
+	c.parts = append(append([]string{}, nd.parts...), sfx)
+	c.Route = route
+	switch {
+	case len(c.Function) == 0:
+		c.Function = sfx
+	case len(c.Literal) == 0:
+		c.Literal = sfx
+	default:
+		c.Literal += "." + sfx
+	}
+	return &c
+}
+
+func toCamel(parts []string) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		for _, w := range strings.Split(p, "_") {
+			if len(w) == 0 {
+				continue
+			}
+			rs := []rune(w)
+			if sb.Len() == 0 {
+				rs[0] = unicode.ToLower(rs[0])
+			} else {
+				rs[0] = unicode.ToUpper(rs[0])
+			}
+			sb.WriteString(string(rs))
+		}
+	}
+	return sb.String()
+}
+
+// moduleDir is the module which has the directory.
+type moduleDir struct {
+	// path is the module path. It is empty if go.mod is not found.
+	path string
+	// rel is the slash-separated directory relative to the module root. It is empty for the root.
+	rel string
+}
+
+// importPath returns the import path of the directory which has filename.
+// It returns empty string if go.mod is not found.
+func (cfg *Config) importPath(filename string) string {
+	m := cfg.moduleDir(filename)
+	if len(m.path) == 0 || len(m.rel) == 0 {
+		return m.path
+	}
+	return m.path + "/" + m.rel
+}
+
+// modulePath returns the slash-separated path of filename relative to the module root.
+// It returns empty string if go.mod is not found.
+func (cfg *Config) modulePath(filename string) string {
+	m := cfg.moduleDir(filename)
+	if len(m.path) == 0 {
+		return ""
+	}
+	return path.Join(m.rel, filepath.Base(filename))
+}
+
+// moduleDir returns the module of the directory which has filename.
+func (cfg *Config) moduleDir(filename string) moduleDir {
+	if len(filename) == 0 {
+		return moduleDir{}
+	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return moduleDir{}
+	}
+	c := cfg.fsCache()
+	if c != nil {
+		if v, ok := c.modules.Load(dir); ok {
+			return v.(moduleDir)
+		}
+	}
+	var m moduleDir
+	for d := dir; ; {
+		if b, err := ioutil.ReadFile(filepath.Join(d, "go.mod")); err == nil {
+			if mp := modfile.ModulePath(b); len(mp) != 0 {
+				m.path = mp
+				if rel, _ := filepath.Rel(d, dir); rel != "." {
+					m.rel = filepath.ToSlash(rel)
+				}
+			}
+			break
+		} else if !os.IsNotExist(err) {
+			break
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	if c != nil {
+		c.modules.Store(dir, m)
+	}
+	return m
+}
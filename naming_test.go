@@ -0,0 +1,87 @@
+package nrseg
+
+import (
+	"testing"
+)
+
+func TestConfig_segName(t *testing.T) {
+	t.Parallel()
+	method := &NameData{
+		Package:    "repo",
+		ImportPath: "github.com/foo/bar/repo",
+		Receiver:   "UserRepo",
+		Function:   "FindByID",
+		File:       "user_repo.go",
+		parts:      []string{"UserRepo", "FindByID"},
+	}
+	lit := (&NameData{
+		Package:  "main",
+		Function: "NewMux",
+		parts:    []string{"NewMux"},
+	}).child("users_id", "/users/{id}")
+	tests := [...]struct {
+		name   string
+		naming string
+		nd     *NameData
+		want   string
+	}{
+		{name: "Default", nd: method, want: "user_repo_find_by_id"},
+		{name: "Snake", naming: NamingSnake, nd: lit, want: "new_mux_users_id"},
+		{name: "Camel", naming: NamingCamel, nd: method, want: "userRepoFindByID"},
+		{name: "CamelLiteral", naming: NamingCamel, nd: lit, want: "newMuxUsersId"},
+		{name: "Dotted", naming: NamingDotted, nd: method, want: "repo.UserRepo.FindByID"},
+		{name: "DottedLiteral", naming: NamingDotted, nd: lit, want: "main.NewMux.users_id"},
+		{name: "Slash", naming: NamingSlash, nd: method, want: "UserRepo/FindByID"},
+		{name: "Template", naming: "{{.ImportPath}}.{{.Receiver}}.{{.Function}}", nd: method, want: "github.com/foo/bar/repo.UserRepo.FindByID"},
+		{name: "TemplateFuncs", naming: "{{snake .File}}#{{camel .Function}}", nd: method, want: "user_repo.go#findByID"},
+		{name: "TemplateRoute", naming: "{{.Function}} {{.Route}}", nd: lit, want: "NewMux /users/{id}"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := &Config{Naming: tt.naming}
+			if err := cfg.Compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.segName(tt.nd); got != tt.want {
+				t.Errorf("segName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_importPath(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		filename, want string
+	}{
+		{filename: "naming.go", want: "github.com/budougumi0617/nrseg"},
+		{filename: "cmd/nrseg/main.go", want: "github.com/budougumi0617/nrseg/cmd/nrseg"},
+		{filename: "testdata/input/basic.go", want: "github.com/budougumi0617/nrseg/testdata/input"},
+		{filename: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := (&Config{}).importPath(tt.filename); got != tt.want {
+			t.Errorf("importPath(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func Test_modulePath(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		filename, want string
+	}{
+		{filename: "naming.go", want: "naming.go"},
+		{filename: "cmd/nrseg/main.go", want: "cmd/nrseg/main.go"},
+		{filename: "testdata/typed/typed.go", want: "typed.go"},
+		{filename: "testdata/typed/broken/broken.go", want: "broken/broken.go"},
+		{filename: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := (&Config{}).modulePath(tt.filename); got != tt.want {
+			t.Errorf("modulePath(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
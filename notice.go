@@ -0,0 +1,207 @@
+package nrseg
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+)
+
+var errNoticeErrors = errors.New("notice_errors is supported only by newrelic backend")
+
+// funcTypeOf returns the type of *ast.FuncDecl or *ast.FuncLit.
+func funcTypeOf(n ast.Node) *ast.FuncType {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		return n.Type
+	case *ast.FuncLit:
+		return n.Type
+	}
+	return nil
+}
+
+// returnsError reports whether the last result of ft is error.
+func returnsError(ft *ast.FuncType) bool {
+	if ft == nil || ft.Results == nil || len(ft.Results.List) == 0 {
+		return false
+	}
+	idt, ok := ft.Results.List[len(ft.Results.List)-1].Type.(*ast.Ident)
+	return ok && idt.Name == "error"
+}
+
+// noticeable reports whether the error result of ft can be noticed.
+// The error result named "_" can not be referred, and nrseg does not rename the results named by the user.
+func noticeable(ft *ast.FuncType) bool {
+	if !returnsError(ft) {
+		return false
+	}
+	names := ft.Results.List[len(ft.Results.List)-1].Names
+	return len(names) == 0 || names[len(names)-1].Name != "_"
+}
+
+// errResultName returns the name of the error result of ft.
+// If the results are unnamed, it names the error result and the others are named "_".
+// The results which are not named can not be returned by the bare return,
+// so the returns of the function are kept as they are.
+// The results named by the user are kept as they are.
+func errResultName(ft *ast.FuncType, body *ast.BlockStmt) string {
+	fields := ft.Results.List
+	last := fields[len(fields)-1]
+	if len(last.Names) != 0 {
+		return last.Names[len(last.Names)-1].Name
+	}
+	for _, fd := range fields[:len(fields)-1] {
+		fd.Names = []*ast.Ident{{NamePos: fd.Type.Pos(), Name: "_"}}
+	}
+	name := freeName(ft, body, "err", "nrErr")
+	last.Names = []*ast.Ident{{NamePos: last.Type.Pos(), Name: name}}
+	return name
+}
+
+// buildNoticeError builds the deferred closure which notices the error result.
+//
+//	defer func() {
+//		if err != nil {
+//			newrelic.FromContext(ctx).NoticeError(err)
+//		}
+//	}()
+func buildNoticeError(t *Target, errName string) ast.Stmt {
+	src := fmt.Sprintf("package p\nfunc _() {\ndefer func() {\nif %[1]s != nil {\n%[2]s.FromContext(%[3]s).NoticeError(%[1]s)\n}\n}()\n}", errName, t.Pkg, t.Ctx)
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		// Ctx is the expression built by nrseg, so it is always valid.
+		panic(err)
+	}
+	s := f.Decls[0].(*ast.FuncDecl).Body.List[0]
+	setPos(s, t.Pos)
+	return s
+}
+
+// isNoticeError reports whether s has the same shape as the statement built by buildNoticeError.
+func isNoticeError(pkg string, s ast.Stmt) bool {
+	ds, ok := s.(*ast.DeferStmt)
+	if !ok || len(ds.Call.Args) != 0 {
+		return false
+	}
+	fl, ok := ds.Call.Fun.(*ast.FuncLit)
+	if !ok || len(fl.Body.List) != 1 {
+		return false
+	}
+	is, ok := fl.Body.List[0].(*ast.IfStmt)
+	if !ok || is.Init != nil || is.Else != nil || len(is.Body.List) != 1 {
+		return false
+	}
+	be, ok := is.Cond.(*ast.BinaryExpr)
+	if !ok || be.Op != token.NEQ {
+		return false
+	}
+	v, ok := be.X.(*ast.Ident)
+	if nl, ok2 := be.Y.(*ast.Ident); !ok || !ok2 || nl.Name != "nil" {
+		return false
+	}
+	es, ok := is.Body.List[0].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	ce, ok := es.X.(*ast.CallExpr)
+	if !ok || len(ce.Args) != 1 {
+		return false
+	}
+	if a, ok := ce.Args[0].(*ast.Ident); !ok || a.Name != v.Name {
+		return false
+	}
+	fc, ok := callMethod(ce, "NoticeError")
+	if !ok || len(fc.Args) != 1 {
+		return false
+	}
+	se, ok := fc.Fun.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != "FromContext" {
+		return false
+	}
+	idt, ok := se.X.(*ast.Ident)
+	return ok && idt.Name == pkg && isCtxExpr(fc.Args[0])
+}
+
+// addNoticeError inserts the closure which notices the error result after the segment of t.
+// n is the number of the statements of the segment, it can include the closure already.
+func addNoticeError(t *funcTarget, tg *Target, n int) {
+	if isNoticeError(tg.Pkg, t.body.List[n-1]) {
+		return
+	}
+	s := buildNoticeError(tg, errResultName(funcTypeOf(t.node), t.body))
+	ss := append([]ast.Stmt{}, t.body.List[:n]...)
+	t.body.List = append(append(ss, s), t.body.List[n:]...)
+}
+
+// errResultPattern matches the names of the error result given by errResultName.
+var errResultPattern = regexp.MustCompile(`^(err|nrErr[0-9]*)$`)
+
+// unnameResults drops the result names which were added by errResultName.
+// Only the results which have the shape given by errResultName are unnamed, the other results are named by the user.
+// The names are kept if the function uses them.
+func unnameResults(ft *ast.FuncType, body *ast.BlockStmt) {
+	fields := ft.Results.List
+	last := fields[len(fields)-1]
+	if len(last.Names) != 1 || !errResultPattern.MatchString(last.Names[0].Name) {
+		return
+	}
+	for _, fd := range fields[:len(fields)-1] {
+		if len(fd.Names) != 1 || fd.Names[0].Name != "_" {
+			return
+		}
+	}
+	if hasBareReturn(body) {
+		return
+	}
+	var used bool
+	ast.Inspect(body, func(nd ast.Node) bool {
+		if idt, ok := nd.(*ast.Ident); ok && idt.Obj != nil && idt.Obj.Decl == last {
+			used = true
+		}
+		return !used
+	})
+	if used {
+		return
+	}
+	for _, fd := range fields {
+		fd.Names = nil
+	}
+}
+
+// hasBareReturn reports whether body has the return statement without the results.
+func hasBareReturn(body *ast.BlockStmt) bool {
+	var result bool
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// the returns in the function literal are its own.
+			return false
+		case *ast.ReturnStmt:
+			result = result || len(n.Results) == 0
+		}
+		return !result
+	})
+	return result
+}
+
+// eachResultFunc calls fn with the functions which have the results in the order of the source.
+// The index is not changed by deleting the statements which do not have such functions.
+func eachResultFunc(f *ast.File, fn func(i int, ft *ast.FuncType, body *ast.BlockStmt)) {
+	var i int
+	ast.Inspect(f, func(n ast.Node) bool {
+		var body *ast.BlockStmt
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		}
+		if ft := funcTypeOf(n); body != nil && ft.Results != nil && len(ft.Results.List) != 0 {
+			fn(i, ft, body)
+			i++
+		}
+		return true
+	})
+}
@@ -0,0 +1,347 @@
+package nrseg
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProcessWithConfig_NoticeErrors(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name string
+		cfg  *Config
+		src  string
+		want string
+	}{
+		{
+			name: "results",
+			cfg:  &Config{NoticeErrors: true},
+			src: `package sample
+
+import (
+	"context"
+)
+
+func Unnamed(ctx context.Context, id int) (string, error) {
+	if err := check(id); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func Blank(ctx context.Context) (n int, _ error) {
+	return
+}
+
+func Named(ctx context.Context) (n int, e error) {
+	return
+}
+
+func Declared(ctx context.Context) error {
+	err := check(1)
+	return err
+}
+
+func NoError(ctx context.Context) int {
+	return 0
+}
+
+func check(int) error { return nil }
+`,
+			want: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Unnamed(ctx context.Context, id int) (_ string, err error) {
+	defer newrelic.FromContext(ctx).StartSegment("unnamed").End()
+	defer func() {
+		if err != nil {
+			newrelic.FromContext(ctx).NoticeError(err)
+		}
+	}()
+	if err := check(id); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func Blank(ctx context.Context) (n int, _ error) {
+	defer newrelic.FromContext(ctx).StartSegment("blank").End()
+	return
+}
+
+func Named(ctx context.Context) (n int, e error) {
+	defer newrelic.FromContext(ctx).StartSegment("named").End()
+	defer func() {
+		if e != nil {
+			newrelic.FromContext(ctx).NoticeError(e)
+		}
+	}()
+	return
+}
+
+func Declared(ctx context.Context) (nrErr error) {
+	defer newrelic.FromContext(ctx).StartSegment("declared").End()
+	defer func() {
+		if nrErr != nil {
+			newrelic.FromContext(ctx).NoticeError(nrErr)
+		}
+	}()
+	err := check(1)
+	return err
+}
+
+func NoError(ctx context.Context) int {
+	defer newrelic.FromContext(ctx).StartSegment("no_error").End()
+	return 0
+}
+
+func check(int) error { return nil }
+`,
+		},
+		{
+			name: "directive",
+			src: `package sample
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// nrseg:notice-error
+func Handler(w http.ResponseWriter, req *http.Request) error {
+	defer newrelic.FromContext(req.Context()).StartSegment("handler").End()
+	return nil
+}
+
+func Other(w http.ResponseWriter, req *http.Request) error {
+	return nil
+}
+`,
+			want: `package sample
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// nrseg:notice-error
+func Handler(w http.ResponseWriter, req *http.Request) (err error) {
+	defer newrelic.FromContext(req.Context()).StartSegment("handler").End()
+	defer func() {
+		if err != nil {
+			newrelic.FromContext(req.Context()).NoticeError(err)
+		}
+	}()
+	return nil
+}
+
+func Other(w http.ResponseWriter, req *http.Request) error {
+	defer newrelic.FromContext(req.Context()).StartSegment("other").End()
+	return nil
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if tt.cfg != nil {
+				if err := tt.cfg.Compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := ProcessWithConfig("sample.go", []byte(tt.src), tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+			// the closure is not inserted twice.
+			got, err = ProcessWithConfig("sample.go", got, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("second run -got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestRemove_NoticeErrors(t *testing.T) {
+	t.Parallel()
+	src := `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Unnamed(ctx context.Context) (_ string, err error) {
+	defer newrelic.FromContext(ctx).StartSegment("unnamed").End()
+	defer func() {
+		if err != nil {
+			newrelic.FromContext(ctx).NoticeError(err)
+		}
+	}()
+	return "", nil
+}
+
+func Named(ctx context.Context) (n int, err error) {
+	defer newrelic.FromContext(ctx).StartSegment("named").End()
+	defer func() {
+		if err != nil {
+			newrelic.FromContext(ctx).NoticeError(err)
+		}
+	}()
+	return
+}
+
+func Unused(ctx context.Context) (_ string, err error) {
+	defer newrelic.FromContext(ctx).StartSegment("unused").End()
+	defer func() {
+		if err != nil {
+			newrelic.FromContext(ctx).NoticeError(err)
+		}
+	}()
+	return
+}
+
+func Used(ctx context.Context) (err error) {
+	defer newrelic.FromContext(ctx).StartSegment("used").End()
+	defer func() {
+		if err != nil {
+			newrelic.FromContext(ctx).NoticeError(err)
+		}
+	}()
+	err = check()
+	return err
+}
+
+func check() error { return nil }
+`
+	want := `package sample
+
+import (
+	"context"
+)
+
+func Unnamed(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func Named(ctx context.Context) (n int, err error) {
+	return
+}
+
+func Unused(ctx context.Context) (_ string, err error) {
+	return
+}
+
+func Used(ctx context.Context) (err error) {
+	err = check()
+	return err
+}
+
+func check() error { return nil }
+`
+	got, err := Remove("sample.go", []byte(src), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
+
+func TestCheck_NoticeErrorDirective(t *testing.T) {
+	t.Parallel()
+	src := `package sample
+
+import "context"
+
+// nrseg:notice-error
+func NoError(ctx context.Context) int {
+	return 0
+}
+
+// nrseg:notice-error always
+func Args(ctx context.Context) error {
+	return nil
+}
+
+// nrseg:notice-error
+func Blank(ctx context.Context) (_ error) {
+	return nil
+}
+`
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, "sample.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := Check(fs, f, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, i := range is {
+		if i.Rule == RuleDirective {
+			got = append(got, i.Message)
+		}
+	}
+	want := []string{"the function does not return error", "does not take any arguments", "the error result is named _, name it to notice the error"}
+	if diff := cmp.Diff(got, want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
+
+func TestRemove_NoticeErrors_RoundTrip(t *testing.T) {
+	t.Parallel()
+	src := `package sample
+
+import (
+	"context"
+)
+
+func A(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func B(ctx context.Context) (n int, err error) {
+	return
+}
+
+func C(ctx context.Context) (n int, err error) {
+	return 0, nil
+}
+`
+	cfg := &Config{NoticeErrors: true}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ProcessWithConfig("sample.go", []byte(src), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = RemoveWithConfig("sample.go", got, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), src); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
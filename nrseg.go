@@ -5,14 +5,17 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/token"
+	"go/parser"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 var (
@@ -22,22 +25,79 @@ var (
 )
 
 type nrseg struct {
-	inspectMode          bool
-	in, dest             string
-	ignoreDirs           []string
+	mode          string
+	in, dest      string
+	args          []string
+	filesFrom     string
+	stdin         bool
+	filename      string
+	since         string
+	ignoreDirs    []string
+	removeAll     bool
+	checkNames    bool
+	diff, list    bool
+	cfg           *Config
+	format        string
+	jobs          int
+	backup        string
+	findings      []Finding
+	base          *baseline
+	writeBaseline string
+	// inspected is the functions which are inspected.
+	inspected   map[baselineEntry]bool
+	minCoverage float64
+	// funcs is the functions counted by stats subcommand per package.
+	funcs                map[string][]funcStat
 	outStream, errStream io.Writer
 	errFlag              bool
+
+	// app is the expression of *newrelic.Application used by wrap-handlers subcommand.
+	app string
+	// unwrapped is the registrations which wrap-handlers subcommand could not rewrite.
+	unwrapped []Unwrapped
+}
+
+const (
+	modeDefault      = ""
+	modeInspect      = "inspect"
+	modeRemove       = "remove"
+	modeStats        = "stats"
+	modeSync         = "sync"
+	modeWrapHandlers = "wrap-handlers"
+)
+
+var usages = map[string]string{
+	modeDefault:      "Insert function segments into any function/method for Newrelic APM.",
+	modeInspect:      "Show functions/methods which do not call function segments.",
+	modeRemove:       "Remove function segments inserted by nrseg from any function/method.",
+	modeStats:        "Show the instrumentation coverage of functions/methods per package.",
+	modeSync:         "Rewrite segment names which do not match function/method names.",
+	modeWrapHandlers: "Wrap HTTP handler registrations by newrelic.WrapHandle or newrelic.WrapHandleFunc.",
+}
+
+func isMode(arg string) bool {
+	switch arg {
+	case modeInspect, modeRemove, modeStats, modeSync, modeWrapHandlers:
+		return true
+	}
+	return false
 }
 
 func fill(args []string, outStream, errStream io.Writer, version, revision string) (*nrseg, error) {
 	cn := args[0]
+	mode := modeDefault
+	fargs := args[1:]
+	if len(fargs) > 0 && isMode(fargs[0]) {
+		mode = fargs[0]
+		fargs = fargs[1:]
+	}
 	flags := flag.NewFlagSet(cn, flag.ContinueOnError)
 	flags.SetOutput(errStream)
 	flags.Usage = func() {
 		fmt.Fprintf(
 			flag.CommandLine.Output(),
-			"Insert function segments into any function/method for Newrelic APM.\n\nUsage of %s:\n",
-			os.Args[0],
+			"%s\n\nUsage of %s:\n",
+			usages[mode], os.Args[0],
 		)
 		flags.PrintDefaults()
 	}
@@ -52,97 +112,264 @@ func fill(args []string, outStream, errStream io.Writer, version, revision strin
 	flags.StringVar(&ignoreDirs, "ignore", "", idesc)
 	flags.StringVar(&ignoreDirs, "i", "", idesc)
 
-	var destDir string
-	odesc := "destination directory."
-	flags.StringVar(&destDir, "destination", "", odesc)
+	var cfgPath string
+	cdesc := "configuration file path.\n(default: " + ConfigFileName + " in the execution path or its parent directories up to the module root.)"
+	flags.StringVar(&cfgPath, "config", "", cdesc)
 
-	if err := flags.Parse(args[1:]); err != nil {
-		return nil, err
+	var naming string
+	ndesc := "segment naming style. snake, camel, dotted, slash or Go template.\nex: \"{{.Package}}.{{.Receiver}}.{{.Function}}\""
+	flags.StringVar(&naming, "naming", "", ndesc)
+
+	var backend string
+	bdesc := "instrumentation backend. newrelic or otel. (default newrelic)"
+	flags.StringVar(&backend, "backend", "", bdesc)
+
+	var tmpl, tmplImport string
+	tdesc := "statement template inserted instead of the backend.\nex: \"defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()\""
+	flags.StringVar(&tmpl, "template", "", tdesc)
+	tidesc := "import path of the package used in the template."
+	flags.StringVar(&tmplImport, "template-import", "", tidesc)
+
+	var clm bool
+	clmdesc := "add code-level metrics attributes to the inserted segments. (newrelic backend only)"
+	flags.BoolVar(&clm, "code-level-metrics", false, clmdesc)
+
+	var notice bool
+	nedesc := "notice the error results to the transaction. (newrelic backend only)"
+	flags.BoolVar(&notice, "notice-errors", false, nedesc)
+
+	var newGoroutine bool
+	ngdesc := "pass the context with NewGoroutine to the go statements. (newrelic backend only)"
+	flags.BoolVar(&newGoroutine, "new-goroutine", false, ngdesc)
+
+	var typed bool
+	tydesc := "detect context parameters and *http.ServeMux with type information.\n(packages which do not type-check use the syntactic detection.)"
+	flags.BoolVar(&typed, "types", false, tydesc)
+
+	var jobs int
+	jdesc := "number of files processed in parallel."
+	flags.IntVar(&jobs, "j", runtime.GOMAXPROCS(0), jdesc)
+
+	var checkNames bool
+	var baselinePath, writeBaselinePath string
+	format := FormatText
+	if mode == modeInspect {
+		cndesc := "report segments whose names do not match the naming style."
+		flags.BoolVar(&checkNames, "names", false, cndesc)
+		fdesc := "output format. text, json, sarif, checkstyle or github."
+		flags.StringVar(&format, "format", FormatText, fdesc)
+		bldesc := "report only the findings which are not in the baseline file."
+		flags.StringVar(&baselinePath, "baseline", "", bldesc)
+		wbdesc := "write the findings to the baseline file instead of reporting them. ex: " + DefaultBaselineFile
+		flags.StringVar(&writeBaselinePath, "write-baseline", "", wbdesc)
 	}
-	if v {
-		fmt.Fprintf(errStream, "%s version %q, revision %q\n", cn, version, revision)
-		return nil, ErrShowVersion
+	var minCoverage float64
+	if mode == modeStats {
+		fdesc := "output format. text, json or html."
+		flags.StringVar(&format, "format", FormatText, fdesc)
+		mcdesc := "exit with non-zero status if the coverage(%) is below the threshold."
+		flags.Float64Var(&minCoverage, "min-coverage", 0, mcdesc)
 	}
+	// inspect and stats do not rewrite files.
+	readOnly := mode == modeInspect || mode == modeStats
 
-	dirs := []string{"testdata"}
-	if len(ignoreDirs) != 0 {
-		dirs = append(dirs, strings.Split(ignoreDirs, ",")...)
+	var destDir string
+	if !readOnly {
+		odesc := "destination directory."
+		flags.StringVar(&destDir, "destination", "", odesc)
 	}
 
-	dir := "./"
-	nargs := flags.Args()
-	if len(nargs) > 1 {
-		msg := "execution path must be only one or no-set(current directory)."
-		return nil, fmt.Errorf(msg)
-	}
-	if len(nargs) == 1 {
-		dir = nargs[0]
-	}
+	var filesFrom string
+	ffdesc := "read the paths from the file, one per line. \"-\" reads them from stdin."
+	flags.StringVar(&filesFrom, "files-from", "", ffdesc)
 
-	return &nrseg{
-		in:         dir,
-		dest:       destDir,
-		ignoreDirs: dirs,
-		outStream:  outStream,
-		errStream:  errStream,
-	}, nil
-}
+	var fromStdin bool
+	sdesc := "read the source from stdin and write the result to stdout."
+	flags.BoolVar(&fromStdin, "stdin", false, sdesc)
+	var filename string
+	fndesc := "file path of the source read by -stdin. It is used to find the configuration, skip files and resolve imports."
+	flags.StringVar(&filename, "filename", "", fndesc)
 
-func fill2(args []string, outStream, errStream io.Writer, version, revision string) (*nrseg, error) {
-	cn := args[0]
-	flags := flag.NewFlagSet(cn, flag.ContinueOnError)
-	flags.SetOutput(errStream)
-	flags.Usage = func() {
-		fmt.Fprintf(
-			flag.CommandLine.Output(),
-			"Insert function segments into any function/method for Newrelic APM.\n\nUsage of %s:\n",
-			os.Args[0],
-		)
-		flags.PrintDefaults()
+	var since string
+	sidesc := "process only the functions changed since the git ref. ex: main, HEAD~3\n(the untracked files are processed too.)"
+	flags.StringVar(&since, "since", "", sidesc)
+
+	var diff, list bool
+	if !readOnly {
+		ddesc := "display diffs instead of rewriting files."
+		flags.BoolVar(&diff, "diff", false, ddesc)
+		flags.BoolVar(&diff, "d", false, ddesc)
+		ldesc := "list files whose result differs from nrseg's and exit with non-zero status."
+		flags.BoolVar(&list, "l", false, ldesc)
 	}
 
-	var v bool
-	vdesc := "print version information and quit."
-	flags.BoolVar(&v, "version", false, vdesc)
-	flags.BoolVar(&v, "v", false, vdesc)
+	var backup string
+	if !readOnly {
+		bkdesc := "keep the original file with the suffix when nrseg rewrites it. ex: .orig"
+		flags.StringVar(&backup, "backup", "", bkdesc)
+	}
 
-	var ignoreDirs string
-	idesc := "ignore directory names. ex: foo,bar,baz\n(testdata directory is always ignored.)"
-	flags.StringVar(&ignoreDirs, "ignore", "", idesc)
-	flags.StringVar(&ignoreDirs, "i", "", idesc)
+	var app string
+	if mode == modeWrapHandlers {
+		apdesc := "expression of *newrelic.Application passed to the wrappers. ex: app, s.nrApp\n(default: the variable found in the function, the receiver or the package.)"
+		flags.StringVar(&app, "app", "", apdesc)
+	}
 
-	if err := flags.Parse(args[2:]); err != nil {
-		return nil, err
+	var all bool
+	if mode == modeRemove {
+		adesc := "remove hand-written segments which are not generated by nrseg too."
+		flags.BoolVar(&all, "all", false, adesc)
+	}
+
+	if err := flags.Parse(fargs); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		// flags printed the error with the usage already.
+		return nil, &UsageError{Err: err}
 	}
 	if v {
 		fmt.Fprintf(errStream, "%s version %q, revision %q\n", cn, version, revision)
 		return nil, ErrShowVersion
 	}
 
-	dirs := []string{"testdata"}
-	if len(ignoreDirs) != 0 {
-		dirs = append(dirs, strings.Split(ignoreDirs, ",")...)
-	}
-
 	dir := "./"
 	nargs := flags.Args()
-	if len(nargs) > 1 {
-		msg := "execution path must be only one or no-set(current directory)."
-		return nil, fmt.Errorf(msg)
+	if len(nargs) != 0 {
+		dir = baseDir(nargs[0])
 	}
-	if len(nargs) == 1 {
-		dir = nargs[0]
+	if fromStdin {
+		if len(nargs) != 0 || len(filesFrom) != 0 || len(destDir) != 0 {
+			return nil, errors.New("-stdin cannot be used with paths, -files-from or -destination")
+		}
+		if len(filename) != 0 {
+			dir = filepath.Dir(filename)
+		}
+	} else if len(filename) != 0 {
+		return nil, errors.New("-filename needs -stdin")
+	}
+	if (mode == modeStats && !validStatsFormat(format)) || (mode != modeStats && !validFormat(format)) {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if len(app) != 0 {
+		if _, err := parser.ParseExpr(app); err != nil {
+			return nil, fmt.Errorf("invalid -app %q: %w", app, err)
+		}
+	}
+	if minCoverage < 0 || minCoverage > 100 {
+		return nil, fmt.Errorf("-min-coverage must be between 0 and 100, but got %v", minCoverage)
+	}
+	var base *baseline
+	if len(baselinePath) != 0 {
+		if len(writeBaselinePath) != 0 {
+			return nil, errors.New("-baseline cannot be used with -write-baseline")
+		}
+		var err error
+		if base, err = loadBaseline(baselinePath); err != nil {
+			return nil, err
+		}
 	}
 
+	if len(cfgPath) == 0 {
+		p, err := findConfig(dir)
+		if err != nil {
+			return nil, err
+		}
+		cfgPath = p
+		// the configuration of the first path is used for all paths.
+		if len(nargs) > 1 {
+			warnConfigs(errStream, nargs, p)
+		}
+	}
+	cfg := &Config{}
+	if len(cfgPath) != 0 {
+		var err error
+		if cfg, err = LoadConfig(cfgPath); err != nil {
+			return nil, err
+		}
+	}
+	// CLI flags which are set explicitly override the configuration file.
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["ignore"] || set["i"] {
+		cfg.Ignore = nil
+		if len(ignoreDirs) != 0 {
+			cfg.Ignore = strings.Split(ignoreDirs, ",")
+		}
+	}
+	if set["naming"] {
+		cfg.Naming = naming
+	}
+	if set["backend"] {
+		cfg.Backend = backend
+		// the template in the configuration file is used instead of the backend.
+		cfg.Template, cfg.TemplateImport = "", ""
+	}
+	if set["template"] {
+		cfg.Template = tmpl
+	}
+	if set["template-import"] {
+		cfg.TemplateImport = tmplImport
+	}
+	if set["types"] {
+		cfg.Types = typed
+	}
+	if set["code-level-metrics"] {
+		cfg.CodeLevelMetrics = clm
+	}
+	if set["notice-errors"] {
+		cfg.NoticeErrors = notice
+	}
+	if set["new-goroutine"] {
+		cfg.NewGoroutine = newGoroutine
+	}
+	if err := cfg.Compile(); err != nil {
+		return nil, err
+	}
+	dirs := append([]string{"testdata"}, cfg.Ignore...)
+
 	return &nrseg{
-		inspectMode: true,
-		in:          dir,
-		ignoreDirs:  dirs,
-		outStream:   outStream,
-		errStream:   errStream,
+		mode:          mode,
+		in:            dir,
+		args:          nargs,
+		filesFrom:     filesFrom,
+		stdin:         fromStdin,
+		filename:      filename,
+		since:         since,
+		dest:          destDir,
+		ignoreDirs:    dirs,
+		removeAll:     all,
+		checkNames:    checkNames,
+		diff:          diff,
+		list:          list,
+		cfg:           cfg,
+		format:        format,
+		base:          base,
+		writeBaseline: writeBaselinePath,
+		inspected:     map[baselineEntry]bool{},
+		minCoverage:   minCoverage,
+		app:           app,
+		funcs:         map[string][]funcStat{},
+		jobs:          jobs,
+		backup:        backup,
+		outStream:     outStream,
+		errStream:     errStream,
 	}, nil
 }
 
+// warnConfigs warns the paths whose configuration file is not cfgPath of the first path.
+func warnConfigs(w io.Writer, args []string, cfgPath string) {
+	used := cfgPath
+	if len(used) == 0 {
+		used = "the default configuration"
+	}
+	for _, a := range args[1:] {
+		if p, err := findConfig(baseDir(a)); err == nil && p != cfgPath {
+			fmt.Fprintf(w, "the configuration of %s is ignored, %s of the first path is used\n", a, used)
+		}
+	}
+}
+
 var c = regexp.MustCompile("(?m)^// Code generated .* DO NOT EDIT\\.$")
 
 func (n *nrseg) skipDir(p string) bool {
@@ -154,119 +381,269 @@ func (n *nrseg) skipDir(p string) bool {
 	return false
 }
 
+// fileResult is the result of a file processed by the worker.
+type fileResult struct {
+	path     string
+	out      bytes.Buffer
+	findings []Finding
+	// inspected is the functions in the inspected file.
+	inspected []baselineEntry
+	// pkg is the package of the file counted by stats subcommand.
+	pkg   string
+	funcs []funcStat
+	// renames is the segment names rewritten by sync subcommand.
+	renames []Rename
+	// unwrapped is the registrations which wrap-handlers subcommand could not rewrite.
+	unwrapped []Unwrapped
+	// flag is true if the file makes the exit status non-zero.
+	flag bool
+	err  error
+}
+
 func (n *nrseg) run() error {
-	return filepath.Walk(n.in, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() && n.skipDir(path) {
-			return filepath.SkipDir
-		}
-		if info.IsDir() {
-			return nil
+	if len(n.since) != 0 {
+		if err := n.cfg.LoadChanges(n.in, n.since); err != nil {
+			return &UsageError{Err: err}
 		}
-		if filepath.Ext(path) != ".go" {
-			return nil
+	}
+	if n.stdin {
+		return n.filter()
+	}
+	args, listed, err := n.targets()
+	if err != nil {
+		return err
+	}
+	if n.cfg != nil && n.cfg.Types {
+		for _, r := range typeRoots(append(args[:len(args):len(args)], listed...)) {
+			if err := n.cfg.LoadTypes(r.dir, r.pattern); err != nil {
+				fmt.Fprintf(n.errStream, "cannot load type information of %s, use syntactic detection: %v\n", r.dir, err)
+			}
 		}
+	}
 
-		if strings.HasSuffix(filepath.Base(path), "_test.go") {
-			return nil
-		}
+	var mu sync.Mutex
+	var results []*fileResult
+	add := func(r *fileResult) {
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	}
 
-		f, err := os.OpenFile(path, os.O_RDWR, 0664)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		org, err := ioutil.ReadAll(f)
-		if err != nil {
-			return err
-		}
+	files := make(chan file)
+	go func() {
+		defer close(files)
+		n.walk(args, listed, func(f file) { files <- f }, func(path string, err error) {
+			add(&fileResult{path: path, err: err})
+		})
+	}()
 
-		if n.inspectMode {
-			return n.Inspect(path, org)
-		} else {
-			got, err := Process(path, org)
-			if err != nil {
-				return err
+	var wg sync.WaitGroup
+	for i := 0; i < n.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				add(n.processFile(f))
 			}
-			if !bytes.Equal(org, got) {
-				if len(n.dest) != 0 && n.in != n.dest {
-					return n.writeOtherPath(n.in, n.dest, path, got)
-				}
-				if _, err := f.WriteAt(got, 0); err != nil {
-					return err
-				}
-			}
-		}
-		return nil
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return filepath.Clean(results[i].path) < filepath.Clean(results[j].path)
 	})
+	var fes FileErrors
+	for _, r := range results {
+		if _, werr := n.outStream.Write(r.out.Bytes()); werr != nil && err == nil {
+			err = werr
+		}
+		n.findings = append(n.findings, r.findings...)
+		for _, e := range r.inspected {
+			n.inspected[e] = true
+		}
+		if len(r.funcs) != 0 {
+			n.funcs[r.pkg] = append(n.funcs[r.pkg], r.funcs...)
+		}
+		n.unwrapped = append(n.unwrapped, r.unwrapped...)
+		if r.flag {
+			n.errFlag = true
+		}
+		if r.err != nil {
+			fes = append(fes, &FileError{Path: r.path, Err: r.err})
+		}
+	}
+	if len(fes) != 0 {
+		return fes
+	}
+	return err
 }
 
-func (n *nrseg) writeOtherPath(in, dist, path string, got []byte) error {
-	p, err := filepath.Rel(in, path)
+func (n *nrseg) workers() int {
+	if n.jobs < 1 {
+		return 1
+	}
+	return n.jobs
+}
+
+func (n *nrseg) processFile(f file) *fileResult {
+	r := &fileResult{path: f.path}
+	r.err = n.process(r, f)
+	return r
+}
+
+func (n *nrseg) process(r *fileResult, f file) error {
+	path := f.path
+	org, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	distabs, err := filepath.Abs(dist)
+
+	switch n.mode {
+	case modeInspect:
+		return n.inspect(r, f, org)
+	case modeStats:
+		return n.stat(r, path, org)
+	}
+	got, err := n.rewrite(r, path, org)
 	if err != nil {
 		return err
 	}
-	dp := filepath.Join(distabs, p)
-	dpd := filepath.Dir(dp)
-	if _, err := os.Stat(dpd); os.IsNotExist(err) {
-		if err := os.Mkdir(dpd, 0777); err != nil {
-			fmt.Fprintf(n.outStream, "create dir failed at %q: %v\n", dpd, err)
+	if bytes.Equal(org, got) {
+		return nil
+	}
+	if n.list || n.diff {
+		return n.report(r, path, org, got)
+	}
+	if len(n.dest) != 0 && f.root != n.dest {
+		err = n.writeOtherPath(&r.out, f.root, n.dest, path, got)
+	} else {
+		err = writeFile(path, got, 0644, n.backup)
+	}
+	if err != nil {
+		return err
+	}
+	writeRenames(&r.out, r.renames)
+	return nil
+}
+
+func (n *nrseg) inspect(r *fileResult, f file, src []byte) error {
+	var err error
+	if r.findings, r.inspected, err = n.Inspect(f.path, src); err != nil {
+		return err
+	}
+	// all functions of the package are inspected, so the entries of the deleted functions are fixed.
+	if f.dir && len(n.since) == 0 && len(r.inspected) != 0 {
+		r.inspected = append(r.inspected, packageEntry(r.inspected[0].Package))
+	}
+	r.flag = len(r.findings) != 0
+	return nil
+}
+
+// rewrite returns the source which the segments are inserted into or removed from.
+// The segment names rewritten by sync subcommand and the registrations which wrap-handlers subcommand
+// could not rewrite are set to r.
+func (n *nrseg) rewrite(r *fileResult, path string, src []byte) ([]byte, error) {
+	switch n.mode {
+	case modeRemove:
+		return RemoveWithConfig(path, src, n.cfg, n.removeAll)
+	case modeSync:
+		got, rs, err := SyncWithConfig(path, src, n.cfg)
+		r.renames = rs
+		return got, err
+	case modeWrapHandlers:
+		got, us, err := WrapHandlersWithConfig(path, src, n.cfg, n.app)
+		r.unwrapped = us
+		r.flag = r.flag || len(us) != 0
+		return got, err
+	}
+	return ProcessWithConfig(path, src, n.cfg)
+}
+
+func (n *nrseg) report(r *fileResult, path string, org, got []byte) error {
+	if n.list {
+		r.flag = true
+		fmt.Fprintln(&r.out, path)
+	}
+	if n.diff {
+		ud := difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(org)),
+			B:        difflib.SplitLines(string(got)),
+			FromFile: filepath.ToSlash(filepath.Join("a", path)),
+			ToFile:   filepath.ToSlash(filepath.Join("b", path)),
+			Context:  3,
+		}
+		if err := difflib.WriteUnifiedDiff(&r.out, ud); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	fmt.Fprintf(n.outStream, "update file %q\n", dp)
-	f, err := os.OpenFile(dp, os.O_RDWR|os.O_CREATE, 0644)
+func (n *nrseg) writeOtherPath(out io.Writer, in, dist, path string, got []byte) error {
+	p, err := filepath.Rel(in, path)
 	if err != nil {
-		return nil
+		return err
 	}
-	defer f.Close()
-	_, err = f.Write(got)
+	distabs, err := filepath.Abs(dist)
 	if err != nil {
-		fmt.Fprintf(n.outStream, "write file failed %v\n", err)
+		return err
 	}
-	fmt.Printf("created at %q\n", dp)
-	return err
-}
-
-func (n *nrseg) reportf(filename string, fs *token.FileSet, pos token.Pos, fd *ast.FuncDecl) {
-	var rcv string
-	if fd.Recv != nil && len(fd.Recv.List) > 0 {
-		if rn, ok := fd.Recv.List[0].Type.(*ast.StarExpr); ok {
-			if idt, ok := rn.X.(*ast.Ident); ok {
-				rcv = idt.Name
-			}
-		} else if idt, ok := fd.Recv.List[0].Type.(*ast.Ident); ok {
-			rcv = idt.Name
-		}
+	dp := filepath.Join(distabs, p)
+	if err := os.MkdirAll(filepath.Dir(dp), 0777); err != nil {
+		return fmt.Errorf("create dir failed: %w", err)
+	}
+	// the new file has the same mode as the original.
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
 	}
 
-	p := fs.File(pos).Position(pos)
-	if len(rcv) != 0 {
-		fmt.Fprintf(n.outStream, "%s:%d:%d: %s.%s no insert segment\n", p.Filename, p.Line, p.Column, rcv, fd.Name.Name)
-		return
+	fmt.Fprintf(out, "update file %q\n", dp)
+	if err := writeFile(dp, got, perm, n.backup); err != nil {
+		return fmt.Errorf("write file failed: %w", err)
 	}
-	fmt.Fprintf(n.outStream, "%s:%d:%d: %s no insert segment\n", p.Filename, p.Line, p.Column, fd.Name.Name)
+	fmt.Fprintf(out, "created at %q\n", dp)
+	return nil
 }
 
 // Run is entry point.
 func Run(args []string, outStream, errStream io.Writer, version, revision string) error {
-	var nrseg *nrseg
-	var err error
-	if len(args) >= 2 && args[1] == "inspect" {
-		nrseg, err = fill2(args, outStream, errStream, version, revision)
-	} else {
-		nrseg, err = fill(args, outStream, errStream, version, revision)
+	nrseg, err := fill(args, outStream, errStream, version, revision)
+	if err != nil {
+		return usageError(errStream, err)
+	}
+	err = nrseg.run()
+	if nrseg.mode == modeInspect {
+		if berr := nrseg.applyBaseline(err); berr != nil && err == nil {
+			err = berr
+		}
+		if werr := writeFindings(outStream, nrseg.format, nrseg.findings); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	if nrseg.mode == modeStats && err == nil {
+		err = nrseg.writeStats()
+	}
+	if nrseg.mode == modeWrapHandlers {
+		writeUnwrapped(errStream, nrseg.unwrapped)
 	}
 	if err != nil {
+		// the errors take precedence over the findings.
+		fmt.Fprintln(errStream, err)
 		return err
 	}
-	err = nrseg.run()
 	if nrseg.errFlag {
-		err = ErrFlagTrue
+		return ErrFlagTrue
 	}
-	return err
+	return nil
+}
+
+// usageError prints err and wraps it by UsageError if it is not reported yet.
+func usageError(errStream io.Writer, err error) error {
+	var ue *UsageError
+	if errors.Is(err, ErrShowVersion) || errors.Is(err, flag.ErrHelp) || errors.As(err, &ue) {
+		return err
+	}
+	fmt.Fprintln(errStream, err)
+	return &UsageError{Err: err}
 }
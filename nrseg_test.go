@@ -50,9 +50,25 @@ func TestNrseg_Run_Inspect(t *testing.T) {
 			want: `testdata/input/basic.go:11:1: S.SampleMethod no insert segment
 testdata/input/basic.go:16:1: SampleFunc no insert segment
 testdata/input/basic.go:21:1: SampleHandler no insert segment
+testdata/input/closure.go:11:21: sampleHandler no insert segment
+testdata/input/closure.go:17:27: NewMux.users no insert segment
+testdata/input/closure.go:23:1: SampleGroup no insert segment
+testdata/input/closure.go:25:8: SampleGroup.func1 no insert segment
 testdata/input/ignore/must_not_change.go:11:1: MustNotChange.SampleMethod no insert segment
 testdata/input/ignore/must_not_change.go:16:1: SampleFunc no insert segment
 testdata/input/ignore/must_not_change.go:21:1: SampleHandler no insert segment
+`,
+		},
+		{
+			name: "parallel",
+			args: []string{"nrseg", "inspect", "-j", "8", "-i", "ignore", "./testdata/input"},
+			want: `testdata/input/basic.go:11:1: S.SampleMethod no insert segment
+testdata/input/basic.go:16:1: SampleFunc no insert segment
+testdata/input/basic.go:21:1: SampleHandler no insert segment
+testdata/input/closure.go:11:21: sampleHandler no insert segment
+testdata/input/closure.go:17:27: NewMux.users no insert segment
+testdata/input/closure.go:23:1: SampleGroup no insert segment
+testdata/input/closure.go:25:8: SampleGroup.func1 no insert segment
 `,
 		},
 		{
@@ -61,6 +77,88 @@ testdata/input/ignore/must_not_change.go:21:1: SampleHandler no insert segment
 			want: `testdata/input/basic.go:11:1: S.SampleMethod no insert segment
 testdata/input/basic.go:16:1: SampleFunc no insert segment
 testdata/input/basic.go:21:1: SampleHandler no insert segment
+testdata/input/closure.go:11:21: sampleHandler no insert segment
+testdata/input/closure.go:17:27: NewMux.users no insert segment
+testdata/input/closure.go:23:1: SampleGroup no insert segment
+testdata/input/closure.go:25:8: SampleGroup.func1 no insert segment
+`,
+		},
+		{
+			name: "config",
+			args: []string{"nrseg", "inspect", "./testdata/config"},
+			want: `testdata/config/sample.go:15:1: Client.FindByID no insert segment
+testdata/config/sample.go:19:1: SampleFunc no insert segment
+`,
+		},
+		{
+			name: "names",
+			args: []string{"nrseg", "inspect", "-names", "-naming", "slash", "-i", "ignore", "./testdata/want"},
+			want: `testdata/want/advance.go:17:1: AlreadyHandler segment name "already_handler" does not match "AlreadyHandler"
+testdata/want/basic.go:13:1: S.SampleMethod segment name "s_sample_method" does not match "S/SampleMethod"
+testdata/want/basic.go:19:1: SampleFunc segment name "sample_func" does not match "SampleFunc"
+testdata/want/basic.go:25:1: SampleHandler segment name "sample_handler" does not match "SampleHandler"
+testdata/want/closure.go:12:21: sampleHandler segment name "sample_handler" does not match "sampleHandler"
+testdata/want/closure.go:19:27: NewMux.users segment name "new_mux_users" does not match "NewMux/users"
+testdata/want/closure.go:26:1: SampleGroup segment name "sample_group" does not match "SampleGroup"
+testdata/want/closure.go:29:8: SampleGroup.func1 segment name "sample_group_func1" does not match "SampleGroup/func1"
+`,
+		},
+		{
+			name: "overrideConfig",
+			args: []string{"nrseg", "inspect", "-i", "other", "./testdata/config"},
+			want: `testdata/config/mock/mock.go:8:1: Ignored no insert segment
+testdata/config/sample.go:15:1: Client.FindByID no insert segment
+testdata/config/sample.go:19:1: SampleFunc no insert segment
+`,
+		},
+		{
+			name: "json",
+			args: []string{"nrseg", "inspect", "-format", "json", "./testdata/config"},
+			want: `{"rule":"missing-segment","file":"testdata/config/sample.go","package":"github.com/budougumi0617/nrseg/testdata/config","line":15,"column":1,"receiver":"Client","function":"FindByID","label":"Client.FindByID","context":"context.Context","segment":"clientFindByID","message":"no insert segment"}
+{"rule":"missing-segment","file":"testdata/config/sample.go","package":"github.com/budougumi0617/nrseg/testdata/config","line":19,"column":1,"function":"SampleFunc","label":"SampleFunc","context":"context.Context","segment":"sampleFunc","message":"no insert segment"}
+`,
+		},
+		{
+			name: "multiple",
+			// the configuration is loaded from the first argument.
+			args: []string{"nrseg", "inspect", "./testdata/config", "./testdata/input/basic.go", "testdata/config/sample.go"},
+			want: `testdata/config/sample.go:15:1: Client.FindByID no insert segment
+testdata/config/sample.go:19:1: SampleFunc no insert segment
+./testdata/input/basic.go:11:1: S.SampleMethod no insert segment
+./testdata/input/basic.go:16:1: SampleFunc no insert segment
+./testdata/input/basic.go:21:1: SampleHandler no insert segment
+`,
+		},
+		{
+			name: "pattern",
+			args: []string{"nrseg", "inspect", "./testdata/.../ignore", "./testdata/typed/broken/..."},
+			want: `testdata/input/ignore/must_not_change.go:11:1: MustNotChange.SampleMethod no insert segment
+testdata/input/ignore/must_not_change.go:16:1: SampleFunc no insert segment
+testdata/input/ignore/must_not_change.go:21:1: SampleHandler no insert segment
+testdata/typed/broken/broken.go:14:1: SampleFunc no insert segment
+testdata/want/ignore/must_not_change.go:11:1: MustNotChange.SampleMethod no insert segment
+testdata/want/ignore/must_not_change.go:16:1: SampleFunc no insert segment
+testdata/want/ignore/must_not_change.go:21:1: SampleHandler no insert segment
+`,
+		},
+		{
+			name: "syntactic",
+			args: []string{"nrseg", "inspect", "./testdata/typed"},
+			want: `testdata/typed/broken/broken.go:14:1: SampleFunc no insert segment
+testdata/typed/typed.go:34:1: Handler no insert segment
+`,
+		},
+		{
+			name: "types",
+			args: []string{"nrseg", "inspect", "-types", "./testdata/typed"},
+			want: `testdata/typed/broken/broken.go:14:1: SampleFunc no insert segment
+testdata/typed/dot.go:8:1: Dot no insert segment
+testdata/typed/stream.go:17:1: S.Watch no insert segment
+testdata/typed/typed.go:22:1: Alias no insert segment
+testdata/typed/typed.go:26:1: S.Interface no insert segment
+testdata/typed/typed.go:30:1: Embed no insert segment
+testdata/typed/typed.go:34:1: Handler no insert segment
+testdata/typed/typed.go:39:7: Closure.f no insert segment
 `,
 		},
 	}
@@ -79,6 +177,117 @@ testdata/input/basic.go:21:1: SampleHandler no insert segment
 	}
 }
 
+func TestNrseg_Run_List(t *testing.T) {
+	tests := [...]struct {
+		name string
+		want string
+		args []string
+	}{
+		{
+			name: "basic",
+			args: []string{"nrseg", "-l", "-i", "ignore", "./testdata/input"},
+			want: "testdata/input/basic.go\ntestdata/input/closure.go\n",
+		},
+		{
+			name: "sequential",
+			args: []string{"nrseg", "-l", "-j", "1", "-i", "ignore", "./testdata/input"},
+			want: "testdata/input/basic.go\ntestdata/input/closure.go\n",
+		},
+		{
+			name: "remove",
+			args: []string{"nrseg", "remove", "-l", "./testdata/want"},
+			want: "testdata/want/advance.go\ntestdata/want/basic.go\ntestdata/want/closure.go\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			errs := &bytes.Buffer{}
+			if err := Run(tt.args, out, errs, "", ""); !errors.Is(err, ErrFlagTrue) {
+				t.Fatalf("want %v, but got %v", ErrFlagTrue, err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("want\n%s\nbut got\n%s", tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestNrseg_Run_Diff(t *testing.T) {
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	args := []string{"nrseg", "-d", "-i", "ignore", "./testdata/input"}
+	if err := Run(args, out, errs, "", ""); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	want := `--- a/testdata/input/basic.go
++++ b/testdata/input/basic.go
+@@ -4,21 +4,26 @@
+ 	"context"
+ 	"fmt"
+ 	"net/http"
++
++	"github.com/newrelic/go-agent/v3/newrelic"
+ )
+ 
+ type S struct{}
+ 
+ func (s *S) SampleMethod(ctx context.Context) {
++	defer newrelic.FromContext(ctx).StartSegment("s_sample_method").End()
+ 	fmt.Println("Hello, playground")
+ 	fmt.Println("end function")
+ }
+ 
+ func SampleFunc(ctx context.Context) {
++	defer newrelic.FromContext(ctx).StartSegment("sample_func").End()
+ 	fmt.Println("Hello, playground")
+ 	fmt.Println("end function")
+ }
+ 
+ func SampleHandler(w http.ResponseWriter, req *http.Request) {
++	defer newrelic.FromContext(req.Context()).StartSegment("sample_handler").End()
+ 	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+ }
+ 
+--- a/testdata/input/closure.go
++++ b/testdata/input/closure.go
+@@ -5,24 +5,29 @@
+ 	"fmt"
+ 	"net/http"
+ 
++	"github.com/newrelic/go-agent/v3/newrelic"
+ 	"golang.org/x/sync/errgroup"
+ )
+ 
+ var sampleHandler = func(w http.ResponseWriter, req *http.Request) {
++	defer newrelic.FromContext(req.Context()).StartSegment("sample_handler").End()
+ 	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+ }
+ 
+ func NewMux() *http.ServeMux {
+ 	mux := http.NewServeMux()
+ 	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
++		defer newrelic.FromContext(r.Context()).StartSegment("new_mux_users").End()
+ 		fmt.Fprintf(w, "Hello, %q", r.URL.Path)
+ 	})
+ 	return mux
+ }
+ 
+ func SampleGroup(ctx context.Context) error {
++	defer newrelic.FromContext(ctx).StartSegment("sample_group").End()
+ 	eg, ctx := errgroup.WithContext(ctx)
+ 	eg.Go(func() error {
++		defer newrelic.FromContext(ctx).StartSegment("sample_group_func1").End()
+ 		fmt.Println(ctx)
+ 		return nil
+ 	})
+`
+	if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
+
 func TestNrseg_run(t *testing.T) {
 	type fields struct {
 		path      string
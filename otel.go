@@ -0,0 +1,184 @@
+package nrseg
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// OpenTelemetryPkg is the import path of OpenTelemetry API.
+const OpenTelemetryPkg = "go.opentelemetry.io/otel"
+
+type otelInstrumenter struct{}
+
+func (otelInstrumenter) ImportPath() string { return OpenTelemetryPkg }
+
+func (otelInstrumenter) PackageName() string { return "otel" }
+
+// Build builds the statements which start the span.
+// The request is rebound with the context which has the span.
+//
+//	ctx, span := otel.Tracer("github.com/foo/bar").Start(ctx, "sample_func")
+//	defer span.End()
+//	req = req.WithContext(ctx) // only *http.Request
+//
+// If the function uses ctx or span already, the other names such as otelCtx and otelSpan are used.
+func (otelInstrumenter) Build(t *Target) []ast.Stmt {
+	pos := t.Pos
+	id := func(name string) *ast.Ident {
+		return &ast.Ident{NamePos: pos, Name: name}
+	}
+	tracer := t.ImportPath
+	if len(tracer) == 0 {
+		tracer = t.Package
+	}
+
+	cn := t.Var
+	rebind := t.Type == TypeHttpRequest && !t.Captured
+	if t.Type != TypeContext {
+		cn = "_"
+		if rebind {
+			cn = t.FreeName("ctx", "otelCtx")
+		}
+	}
+	span := t.FreeName("span", "otelSpan")
+	ss := []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs:    []ast.Expr{id(cn), id(span)},
+			TokPos: pos,
+			Tok:    token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: &ast.CallExpr{
+						Fun:    &ast.SelectorExpr{X: id(t.Pkg), Sel: id("Tracer")},
+						Lparen: pos,
+						Args:   []ast.Expr{&ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(tracer)}},
+						Rparen: pos,
+					},
+					Sel: id("Start"),
+				},
+				Lparen: pos,
+				Args:   []ast.Expr{t.CtxExpr(), &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(t.Name)}},
+				Rparen: pos,
+			}},
+		},
+		&ast.DeferStmt{
+			Defer: pos,
+			Call: &ast.CallExpr{
+				Fun:    &ast.SelectorExpr{X: id(span), Sel: id("End")},
+				Lparen: pos,
+				Rparen: pos,
+			},
+		},
+	}
+	if rebind {
+		ss = append(ss, &ast.AssignStmt{
+			Lhs:    []ast.Expr{id(t.Var)},
+			TokPos: pos,
+			Tok:    token.ASSIGN,
+			Rhs: []ast.Expr{&ast.CallExpr{
+				Fun:    &ast.SelectorExpr{X: id(t.Var), Sel: id("WithContext")},
+				Lparen: pos,
+				Args:   []ast.Expr{id(cn)},
+				Rparen: pos,
+			}},
+		})
+	}
+	return ss
+}
+
+func (otelInstrumenter) Instrumented(pkg string, s ast.Stmt) bool {
+	var result bool
+	ast.Inspect(s, func(n ast.Node) bool {
+		if se, ok := n.(*ast.SelectorExpr); ok {
+			if idt, ok := se.X.(*ast.Ident); ok && idt.Name == pkg && se.Sel.Name == "Tracer" {
+				result = true
+			}
+		}
+		return !result
+	})
+	return result
+}
+
+func (otelInstrumenter) SegmentName(_ string, s ast.Stmt) (string, bool) {
+	var result string
+	var found bool
+	ast.Inspect(s, func(n ast.Node) bool {
+		if ce, ok := n.(*ast.CallExpr); ok && len(ce.Args) == 2 {
+			if se, ok := ce.Fun.(*ast.SelectorExpr); ok && se.Sel.Name == "Start" {
+				if bl, ok := ce.Args[1].(*ast.BasicLit); ok && bl.Kind == token.STRING {
+					if sn, err := strconv.Unquote(bl.Value); err == nil {
+						result, found = sn, true
+					}
+				}
+			}
+		}
+		return !found
+	})
+	return result, found
+}
+
+func (otelInstrumenter) Generated(pkg string, ss []ast.Stmt, all bool) int {
+	as, ok := ss[0].(*ast.AssignStmt)
+	if !ok || as.Tok != token.DEFINE || len(as.Lhs) != 2 || len(as.Rhs) != 1 {
+		return 0
+	}
+	cn, ok1 := as.Lhs[0].(*ast.Ident)
+	span, ok2 := as.Lhs[1].(*ast.Ident)
+	start, ok3 := as.Rhs[0].(*ast.CallExpr)
+	if !ok1 || !ok2 || !ok3 || len(start.Args) != 2 {
+		return 0
+	}
+	tc, ok := callMethod(start, "Start")
+	if !ok || len(tc.Args) != 1 {
+		return 0
+	}
+	if se, ok := tc.Fun.(*ast.SelectorExpr); !ok || se.Sel.Name != "Tracer" {
+		return 0
+	} else if idt, ok := se.X.(*ast.Ident); !ok || idt.Name != pkg {
+		return 0
+	}
+	if !all && !(isStringLit(tc.Args[0]) && isStringLit(start.Args[1])) {
+		return 0
+	}
+
+	// defer span.End()
+	if len(ss) < 2 {
+		return 0
+	}
+	ds, ok := ss[1].(*ast.DeferStmt)
+	if !ok || len(ds.Call.Args) != 0 {
+		return 0
+	}
+	if se, ok := ds.Call.Fun.(*ast.SelectorExpr); !ok || se.Sel.Name != "End" {
+		return 0
+	} else if idt, ok := se.X.(*ast.Ident); !ok || idt.Name != span.Name {
+		return 0
+	}
+
+	// req = req.WithContext(ctx)
+	if len(ss) < 3 {
+		return 2
+	}
+	rs, ok := ss[2].(*ast.AssignStmt)
+	if !ok || rs.Tok != token.ASSIGN || len(rs.Lhs) != 1 || len(rs.Rhs) != 1 {
+		return 2
+	}
+	ce, ok := rs.Rhs[0].(*ast.CallExpr)
+	if !ok || len(ce.Args) != 1 {
+		return 2
+	}
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != "WithContext" {
+		return 2
+	}
+	if arg, ok := ce.Args[0].(*ast.Ident); !ok || arg.Name != cn.Name {
+		return 2
+	}
+	return 3
+}
+
+func isStringLit(e ast.Expr) bool {
+	bl, ok := e.(*ast.BasicLit)
+	return ok && bl.Kind == token.STRING
+}
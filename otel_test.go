@@ -0,0 +1,294 @@
+package nrseg
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProcessWithConfig_OpenTelemetry(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, src, want string
+	}{
+		{
+			name: "Basic",
+			src: `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+func SampleFunc(ctx context.Context) {
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+
+func SampleRouter(mux *http.ServeMux, req *http.Request) {
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+	})
+}
+`,
+			want: `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer("main").Start(ctx, "sample_func")
+	defer span.End()
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span := otel.Tracer("main").Start(req.Context(), "sample_handler")
+	defer span.End()
+	req = req.WithContext(ctx)
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+
+func SampleRouter(mux *http.ServeMux, req *http.Request) {
+	ctx, span := otel.Tracer("main").Start(req.Context(), "sample_router")
+	defer span.End()
+	req = req.WithContext(ctx)
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("main").Start(r.Context(), "sample_router_users")
+		defer span.End()
+		r = r.WithContext(ctx)
+		fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+	})
+}
+`,
+		},
+		{
+			name: "Collision",
+			src: `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func SampleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_ = ctx
+}
+
+func SampleFunc(ctx context.Context) {
+	span := 2
+	otelSpan := span
+	_ = otelSpan
+}
+`,
+			want: `package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleHandler(w http.ResponseWriter, r *http.Request) {
+	otelCtx, span := otel.Tracer("main").Start(r.Context(), "sample_handler")
+	defer span.End()
+	r = r.WithContext(otelCtx)
+	ctx := r.Context()
+	_ = ctx
+}
+
+func SampleFunc(ctx context.Context) {
+	ctx, otelSpan2 := otel.Tracer("main").Start(ctx, "sample_func")
+	defer otelSpan2.End()
+	span := 2
+	otelSpan := span
+	_ = otelSpan
+}
+`,
+		},
+		{
+			name: "Instrumented",
+			src: `package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer("foo").Start(ctx, "custom")
+	defer span.End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer("foo").Start(ctx, "custom")
+	defer span.End()
+}
+`,
+		},
+	}
+	cfg := &Config{Backend: BackendOpenTelemetry}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ProcessWithConfig("", []byte(tt.src), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestRemoveWithConfig_OpenTelemetry(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, src, want string
+		all             bool
+	}{
+		{
+			name: "Generated",
+			src: `package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer("main").Start(ctx, "sample_func")
+	defer span.End()
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span := otel.Tracer("main").Start(req.Context(), "sample_handler")
+	defer span.End()
+	req = req.WithContext(ctx)
+	w.WriteHeader(http.StatusOK)
+}
+`,
+			want: `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func SampleFunc(ctx context.Context) {
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+`,
+		},
+		{
+			name: "KeepHandWritten",
+			src: `package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+const name = "tracer"
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer(name).Start(ctx, "sample_func")
+	defer span.End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+const name = "tracer"
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer(name).Start(ctx, "sample_func")
+	defer span.End()
+}
+`,
+		},
+		{
+			name: "RemoveAll",
+			all:  true,
+			src: `package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+const name = "tracer"
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer(name).Start(ctx, "sample_func")
+	defer span.End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+)
+
+const name = "tracer"
+
+func SampleFunc(ctx context.Context) {
+}
+`,
+		},
+	}
+	cfg := &Config{Backend: BackendOpenTelemetry}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := RemoveWithConfig("", []byte(tt.src), cfg, tt.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
@@ -16,6 +16,12 @@ import (
 )
 
 func Process(filename string, src []byte) ([]byte, error) {
+	return ProcessWithConfig(filename, src, nil)
+}
+
+// ProcessWithConfig inserts function segments into src with cfg.
+// cfg can be nil, then Process uses the default configuration.
+func ProcessWithConfig(filename string, src []byte, cfg *Config) ([]byte, error) {
 	if len(src) != 0 && c.Match(src) {
 		return src, nil
 	}
@@ -24,9 +30,13 @@ func Process(filename string, src []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ignoredFile(fs, f) {
+		return src, nil
+	}
 	// import newrelic pkg
-	pkg := "newrelic"
-	name, err := addImport(fs, f) // importされたpkgの名前
+	ins := cfg.instrumenter()
+	pkg := ins.PackageName()
+	name, err := addImport(fs, f, ins.ImportPath(), cfg.importAlias()) // importされたpkgの名前
 	if err != nil {
 		return nil, err
 	}
@@ -35,32 +45,13 @@ func Process(filename string, src []byte) ([]byte, error) {
 		pkg = name
 	}
 
-	ast.Inspect(f, func(n ast.Node) bool {
-		if fd, ok := n.(*ast.FuncDecl); ok {
-			if findIgnoreComment(fd.Doc) {
-				return false
-			}
-			if fd.Body != nil && len(fd.Body.List) > 0 {
-				sn := getSegName(fd)
-				vn, t := parseParams(f.Imports, fd.Type)
-				var ds ast.Stmt
-				switch t {
-				case TypeContext:
-					ds = buildDeferStmt(fd.Body.Lbrace, pkg, vn, sn)
-				case TypeHttpRequest:
-					ds = buildDeferStmtWithHttpRequest(fd.Body.Lbrace, pkg, vn, sn)
-				case TypeUnknown:
-					return false
-				}
-
-				if !existFromContext(pkg, fd.Body.List[0]) {
-					fd.Body.List = append([]ast.Stmt{ds}, fd.Body.List...)
-				}
-				return false
-			}
+	for _, t := range findTargets(fs, f, cfg) {
+		instrument(ins, pkg, t)
+		// the go statements are rewritten after the check of the instrumentation.
+		if cfg.newGoroutine() {
+			propagateGoroutines(f, t, pkg)
 		}
-		return true
-	})
+	}
 
 	// gofmt
 	var fmtedBuf bytes.Buffer
@@ -74,19 +65,47 @@ func Process(filename string, src []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	if ni, ok := ins.(newrelicInstrumenter); ok && ni.codeLevelMetrics {
+		// the inserted statements and imports move the functions.
+		return fixLinenos(filename, igot)
+	}
 	return igot, nil
 }
 
+// instrument inserts the statements built by ins into t.
+func instrument(ins Instrumenter, pkg string, t *funcTarget) {
+	tg := t.target(pkg)
+	var n int
+	if ins.Instrumented(pkg, t.body.List[0]) {
+		if !t.notice {
+			return
+		}
+		// notice the error of the function which was instrumented already.
+		if n = ins.(Remover).Generated(pkg, t.body.List, true); n == 0 {
+			return
+		}
+		// the closure is printed right after the segment.
+		tg.Pos = t.body.List[n-1].End()
+	} else {
+		ss := ins.Build(tg)
+		n = len(ss)
+		t.body.List = append(ss, t.body.List...)
+	}
+	if t.notice {
+		addNoticeError(t, tg, n)
+	}
+}
+
 const NewRelicV3Pkg = "github.com/newrelic/go-agent/v3/newrelic"
 
-func addImport(fs *token.FileSet, f *ast.File) (string, error) {
-	pkg, err := findImport(fs, f)
+func addImport(fs *token.FileSet, f *ast.File, path, alias string) (string, error) {
+	pkg, err := findImport(f, path)
 	if err == nil {
 		return pkg, nil
 	}
 	if errors.Is(err, ErrNoImportNrPkg) {
-		astutil.AddImport(fs, f, NewRelicV3Pkg)
-		return "", nil
+		astutil.AddNamedImport(fs, f, alias, path)
+		return alias, nil
 	}
 
 	return "", err
@@ -94,14 +113,14 @@ func addImport(fs *token.FileSet, f *ast.File) (string, error) {
 
 var ErrNoImportNrPkg = errors.New("not import newrelic pkg")
 
-func findImport(fs *token.FileSet, f *ast.File) (string, error) {
+func findImport(f *ast.File, ip string) (string, error) {
 	for _, spec := range f.Imports {
 		path, err := strconv.Unquote(spec.Path.Value)
 		if err != nil {
 			return "", err
 		}
 		// import already.
-		if path == NewRelicV3Pkg {
+		if path == ip {
 			if spec.Name != nil {
 				return spec.Name.Name, nil
 			}
@@ -111,20 +130,6 @@ func findImport(fs *token.FileSet, f *ast.File) (string, error) {
 	return "", ErrNoImportNrPkg
 }
 
-var nrignoreReg = regexp.MustCompile("(?m)^// nrseg:ignore .*$")
-
-func findIgnoreComment(cg *ast.CommentGroup) bool {
-	if cg == nil {
-		return false
-	}
-	for _, c := range cg.List {
-		if nrignoreReg.MatchString(c.Text) {
-			return true
-		}
-	}
-	return false
-}
-
 func existFromContext(pn string, s ast.Stmt) bool {
 	var result bool
 	ast.Inspect(s, func(n ast.Node) bool {
@@ -139,6 +144,25 @@ func existFromContext(pn string, s ast.Stmt) bool {
 	return result
 }
 
+// findSegName returns the segment name which is passed to StartSegment in s.
+func findSegName(s ast.Stmt) (string, bool) {
+	var result string
+	var found bool
+	ast.Inspect(s, func(n ast.Node) bool {
+		if ce, ok := n.(*ast.CallExpr); ok && len(ce.Args) == 1 {
+			if se, ok := ce.Fun.(*ast.SelectorExpr); ok && se.Sel.Name == "StartSegment" {
+				if bl, ok := ce.Args[0].(*ast.BasicLit); ok && bl.Kind == token.STRING {
+					if sn, err := strconv.Unquote(bl.Value); err == nil {
+						result, found = sn, true
+					}
+				}
+			}
+		}
+		return !found
+	})
+	return result, found
+}
+
 // buildDeferStmt builds the defer statement with args.
 // ex:
 //    defer newrelic.FromContext(ctx).StartSegment("slow").End()
@@ -191,24 +215,6 @@ func skeletonDeferStmt(pos token.Pos, fcArg ast.Expr, pkgName, segName string) *
 	}
 }
 
-func getSegName(fd *ast.FuncDecl) string {
-	var prefix string
-	if fd.Recv != nil && len(fd.Recv.List) > 0 {
-		if rn, ok := fd.Recv.List[0].Type.(*ast.StarExpr); ok {
-			if idt, ok := rn.X.(*ast.Ident); ok {
-				prefix = toSnake(idt.Name)
-			}
-		} else if idt, ok := fd.Recv.List[0].Type.(*ast.Ident); ok {
-			prefix = toSnake(idt.Name)
-		}
-	}
-	sn := toSnake(fd.Name.Name)
-	if len(prefix) != 0 {
-		sn = prefix + "_" + sn
-	}
-	return sn
-}
-
 // https://www.golangprograms.com/golang-convert-string-into-snake-case.html
 var matchFirstCap = regexp.MustCompile("(.)([A-Z][a-z]+)")
 var matchAllCap = regexp.MustCompile("([a-z0-9])([A-Z])")
@@ -235,20 +241,20 @@ func parseParams(is []*ast.ImportSpec, t *ast.FuncType) (string, string) {
 	var hname = getImportName(is, TypeHttpRequest)
 	n, typ := "", TypeUnknown
 	for _, f := range t.Params.List {
+		name := paramName(f)
+		if len(name) == 0 {
+			continue
+		}
 		if se, ok := f.Type.(*ast.SelectorExpr); ok {
 			if idt, ok := se.X.(*ast.Ident); ok && idt.Name == cname && se.Sel.Name == "Context" {
-				if len(f.Names) > 0 && len(f.Names[0].Name) > 0 {
-					return f.Names[0].Name, TypeContext
-				}
+				return name, TypeContext
 			}
 		}
-		if se, ok := f.Type.(*ast.StarExpr); ok {
+		if se, ok := f.Type.(*ast.StarExpr); ok && typ == TypeUnknown {
 			if se, ok := se.X.(*ast.SelectorExpr); ok {
 				if idt, ok := se.X.(*ast.Ident); ok && idt.Name == hname && se.Sel.Name == "Request" {
-					if len(f.Names) > 0 && len(f.Names[0].Name) > 0 {
-						n = f.Names[0].Name
-						typ = TypeHttpRequest
-					}
+					n = name
+					typ = TypeHttpRequest
 				}
 			}
 		}
@@ -256,6 +262,16 @@ func parseParams(is []*ast.ImportSpec, t *ast.FuncType) (string, string) {
 	return n, typ
 }
 
+// paramName returns the first name of f which is not blank.
+func paramName(f *ast.Field) string {
+	for _, n := range f.Names {
+		if n.Name != "_" {
+			return n.Name
+		}
+	}
+	return ""
+}
+
 func getImportName(is []*ast.ImportSpec, typ string) string {
 	var def = strings.Replace(strings.Split(typ, ".")[0], "*", "", 1)
 	for _, i := range is {
@@ -241,6 +241,73 @@ func SampleHandler(w http.ResponseWriter, req *http.Request) {
 	defer newrelic.FromContext(req.Context()).StartSegment("sample_handler").End()
 	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
 }
+`,
+		},
+		{
+			name: "FuncLit",
+			src: `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+var h = func(ctx context.Context) {
+	fmt.Println("Hello, playground")
+}
+
+func (s *S) Route(mux *http.ServeMux) {
+	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+	})
+}
+
+func SampleFunc(ctx context.Context) {
+	fmt.Println("Hello, playground")
+	go func() {
+		fmt.Println(ctx)
+	}()
+	f := func(n int) {
+		fmt.Println(n)
+	}
+	f(1)
+}
+`,
+			want: `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+var h = func(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("h").End()
+	fmt.Println("Hello, playground")
+}
+
+func (s *S) Route(mux *http.ServeMux) {
+	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, req *http.Request) {
+		defer newrelic.FromContext(req.Context()).StartSegment("s_route_users_id").End()
+		fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+	})
+}
+
+func SampleFunc(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("sample_func").End()
+	fmt.Println("Hello, playground")
+	go func() {
+		defer newrelic.FromContext(ctx).StartSegment("sample_func_func1").End()
+		fmt.Println(ctx)
+	}()
+	f := func(n int) {
+		fmt.Println(n)
+	}
+	f(1)
+}
 `,
 		},
 		{
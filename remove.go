@@ -0,0 +1,319 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Remove deletes function segments which were inserted by Process from src.
+// If all is true, it also deletes hand-written segments which have the different shape.
+// ex:
+//
+//	defer txn.StartSegment("slow").End()
+func Remove(filename string, src []byte, all bool) ([]byte, error) {
+	return RemoveWithConfig(filename, src, nil, all)
+}
+
+// RemoveWithConfig deletes the instrumentation of the backend in cfg from src.
+func RemoveWithConfig(filename string, src []byte, cfg *Config, all bool) ([]byte, error) {
+	if len(src) != 0 && c.Match(src) {
+		return src, nil
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	ins := cfg.instrumenter()
+	rm, ok := ins.(Remover)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support remove", ins)
+	}
+	name, err := findImport(f, ins.ImportPath())
+	if errors.Is(err, ErrNoImportNrPkg) {
+		return src, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pkg := ins.PackageName()
+	if len(name) != 0 {
+		pkg = name
+	}
+
+	// nrseg inserts the segments only at the top of the function bodies,
+	// so the other statements are removed only if all is true.
+	var rs []ast.Stmt
+	if all {
+		rs = allSegmentStmts(f, rm, pkg)
+		rs = append(rs, unusedTxnStmts(f, pkg, rs)...)
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Pos() < rs[j].Pos() })
+	} else {
+		rs = generatedStmts(f, rm, pkg)
+	}
+	// the go statements are checked on f which is parsed again after the statements are cut.
+	if len(rs) == 0 && !revertGoroutines(f, pkg) {
+		return src, nil
+	}
+	removed := map[ast.Stmt]bool{}
+	for _, s := range rs {
+		removed[s] = true
+	}
+	// noticed is the functions whose error results were noticed.
+	noticed := map[int]bool{}
+	eachResultFunc(f, func(i int, _ *ast.FuncType, body *ast.BlockStmt) {
+		for _, s := range body.List {
+			if removed[s] && isNoticeError(pkg, s) {
+				noticed[i] = true
+			}
+		}
+	})
+	src = cutStmts(fs, src, rs)
+
+	fs = token.NewFileSet()
+	f, err = parser.ParseFile(fs, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	eachResultFunc(f, func(i int, ft *ast.FuncType, body *ast.BlockStmt) {
+		if noticed[i] {
+			unnameResults(ft, body)
+		}
+	})
+	// the go statements are rewritten by NewGoroutine option.
+	revertGoroutines(f, pkg)
+	if !usePkg(f, pkg) {
+		astutil.DeleteNamedImport(fs, f, name, ins.ImportPath())
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fs, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// generatedStmts returns the statements which were inserted by nrseg at the top of the function bodies in f.
+func generatedStmts(f *ast.File, rm Remover, pkg string) []ast.Stmt {
+	var rs []ast.Stmt
+	ast.Inspect(f, func(n ast.Node) bool {
+		var body *ast.BlockStmt
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		}
+		if body == nil || len(body.List) == 0 {
+			return true
+		}
+		if g := rm.Generated(pkg, body.List, false); g > 0 {
+			rs = append(rs, body.List[:g]...)
+		}
+		return true
+	})
+	return rs
+}
+
+// allSegmentStmts returns the statements of the segments of any shape in the blocks of f.
+func allSegmentStmts(f *ast.File, rm Remover, pkg string) []ast.Stmt {
+	var rs []ast.Stmt
+	ast.Inspect(f, func(n ast.Node) bool {
+		bs, ok := n.(*ast.BlockStmt)
+		if !ok {
+			return true
+		}
+		for i := 0; i < len(bs.List); i++ {
+			if g := rm.Generated(pkg, bs.List[i:], true); g > 0 {
+				rs = append(rs, bs.List[i:i+g]...)
+				i += g - 1
+			}
+		}
+		return true
+	})
+	return rs
+}
+
+// unusedTxnStmts returns the declarations of the transactions which are used only by rs.
+// ex:
+//
+//	txn := newrelic.FromContext(ctx)
+//	defer txn.StartSegment("slow").End()
+func unusedTxnStmts(f *ast.File, pkg string, rs []ast.Stmt) []ast.Stmt {
+	// uses is the references of the variables which are out of rs.
+	uses := map[*ast.Object]int{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if s, ok := n.(ast.Stmt); ok && containsStmt(rs, s) {
+			return false
+		}
+		if idt, ok := n.(*ast.Ident); ok && idt.Obj != nil {
+			uses[idt.Obj]++
+		}
+		return true
+	})
+	var txns []ast.Stmt
+	ast.Inspect(f, func(n ast.Node) bool {
+		as, ok := n.(*ast.AssignStmt)
+		if !ok || as.Tok != token.DEFINE || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+			return true
+		}
+		idt, ok := as.Lhs[0].(*ast.Ident)
+		if !ok || idt.Obj == nil || !isFromContext(pkg, as.Rhs[0]) {
+			return true
+		}
+		// the declaration itself is the only reference.
+		if uses[idt.Obj] == 1 {
+			txns = append(txns, as)
+		}
+		return true
+	})
+	return txns
+}
+
+func containsStmt(ss []ast.Stmt, s ast.Stmt) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isFromContext reports whether e gets the transaction from the context. ex: newrelic.FromContext(ctx)
+func isFromContext(pkg string, e ast.Expr) bool {
+	ce, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != "FromContext" {
+		return false
+	}
+	idt, ok := se.X.(*ast.Ident)
+	return ok && idt.Name == pkg && idt.Obj == nil
+}
+
+// cutStmts deletes the lines of rs from src.
+// rs must be sorted by position.
+func cutStmts(fs *token.FileSet, src []byte, rs []ast.Stmt) []byte {
+	var buf bytes.Buffer
+	var prev int
+	for _, s := range rs {
+		start, end := fs.Position(s.Pos()).Offset, fs.Position(s.End()).Offset
+		for start > 0 && (src[start-1] == ' ' || src[start-1] == '\t') {
+			start--
+		}
+		for end < len(src) && (src[end] == ' ' || src[end] == '\t' || src[end] == ';') {
+			end++
+		}
+		if end < len(src) && src[end] == '\n' {
+			end++
+		}
+		buf.Write(src[prev:start])
+		prev = end
+	}
+	buf.Write(src[prev:])
+	return buf.Bytes()
+}
+
+// isGeneratedSegment reports whether s has the same shape as the statement built by skeletonDeferStmt.
+func isGeneratedSegment(pkg string, s ast.Stmt) bool {
+	ds, ok := s.(*ast.DeferStmt)
+	if !ok {
+		return false
+	}
+	if len(ds.Call.Args) != 0 {
+		return false
+	}
+	ss, ok := callMethod(ds.Call, "End")
+	return ok && isGeneratedStart(pkg, ss)
+}
+
+// isGeneratedStart reports whether ce starts the segment with the generated shape.
+// ex:
+//
+//	newrelic.FromContext(ctx).StartSegment("sample_func")
+func isGeneratedStart(pkg string, ce *ast.CallExpr) bool {
+	if len(ce.Args) != 1 {
+		return false
+	}
+	if bl, ok := ce.Args[0].(*ast.BasicLit); !ok || bl.Kind != token.STRING {
+		return false
+	}
+	fc, ok := callMethod(ce, "StartSegment")
+	if !ok || len(fc.Args) != 1 {
+		return false
+	}
+	if se, ok := fc.Fun.(*ast.SelectorExpr); !ok || se.Sel.Name != "FromContext" {
+		return false
+	} else if idt, ok := se.X.(*ast.Ident); !ok || idt.Name != pkg {
+		return false
+	}
+	return isCtxExpr(fc.Args[0])
+}
+
+// isCtxExpr reports whether e is the expression built from the parameter.
+// ex: ctx, req.Context(), c.Request().Context()
+func isCtxExpr(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		return isCtxExpr(e.X)
+	case *ast.CallExpr:
+		_, ok := e.Fun.(*ast.SelectorExpr)
+		return ok && len(e.Args) == 0 && isCtxExpr(e.Fun)
+	}
+	return false
+}
+
+// isSegment reports whether s is a deferred segment end of any shape.
+// ex:
+//
+//	defer txn.StartSegment(name).End()
+func isSegment(s ast.Stmt) bool {
+	ds, ok := s.(*ast.DeferStmt)
+	if !ok {
+		return false
+	}
+	ss, ok := callMethod(ds.Call, "End")
+	return ok && isStartSegment(ss)
+}
+
+// isStartSegment reports whether ce calls StartSegment of any receiver.
+func isStartSegment(ce *ast.CallExpr) bool {
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	return ok && se.Sel.Name == "StartSegment"
+}
+
+// callMethod returns the receiver expression of ce if ce calls the method which has name.
+func callMethod(ce *ast.CallExpr, name string) (*ast.CallExpr, bool) {
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != name {
+		return nil, false
+	}
+	x, ok := se.X.(*ast.CallExpr)
+	return x, ok
+}
+
+func usePkg(f *ast.File, pkg string) bool {
+	var result bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		if se, ok := n.(*ast.SelectorExpr); ok {
+			if idt, ok := se.X.(*ast.Ident); ok && idt.Name == pkg && idt.Obj == nil {
+				result = true
+				return false
+			}
+		}
+		return !result
+	})
+	return result
+}
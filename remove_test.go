@@ -0,0 +1,277 @@
+package nrseg
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, src, want string
+		all             bool
+	}{
+		{
+			name: "BasicRemove",
+			src: `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type Foo struct{}
+
+func (f *Foo) SampleMethod(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("foo_sample_method").End()
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	defer newrelic.FromContext(req.Context()).StartSegment("sample_handler").End()
+	// comment 1
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+`,
+			want: `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type Foo struct{}
+
+func (f *Foo) SampleMethod(ctx context.Context) {
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	// comment 1
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+`,
+		},
+		{
+			name: "NamedImport",
+			src: `package main
+
+import (
+	"context"
+
+	nr "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	defer nr.FromContext(ctx).StartSegment("sample_func").End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+)
+
+func SampleFunc(ctx context.Context) {
+}
+`,
+		},
+		{
+			name: "KeepHandWritten",
+			src: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("sample_func").End()
+	txn := newrelic.FromContext(ctx)
+	defer txn.StartSegment("hand_written").End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	txn := newrelic.FromContext(ctx)
+	defer txn.StartSegment("hand_written").End()
+}
+`,
+		},
+		{
+			name: "KeepGeneratedShapeInBody",
+			src: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("sample_func").End()
+	if ctx != nil {
+		defer newrelic.FromContext(ctx).StartSegment("hand_written").End()
+	}
+	defer newrelic.FromContext(ctx).StartSegment("hand_written2").End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	if ctx != nil {
+		defer newrelic.FromContext(ctx).StartSegment("hand_written").End()
+	}
+	defer newrelic.FromContext(ctx).StartSegment("hand_written2").End()
+}
+`,
+		},
+		{
+			name: "RemoveAllInBody",
+			all:  true,
+			src: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	if ctx != nil {
+		defer newrelic.FromContext(ctx).StartSegment("hand_written").End()
+	}
+}
+`,
+			want: `package main
+
+import (
+	"context"
+)
+
+func SampleFunc(ctx context.Context) {
+	if ctx != nil {
+	}
+}
+`,
+		},
+		{
+			name: "RemoveAll",
+			all:  true,
+			src: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context, name string) {
+	defer newrelic.FromContext(ctx).StartSegment(name).End()
+}
+`,
+			want: `package main
+
+import (
+	"context"
+)
+
+func SampleFunc(ctx context.Context, name string) {
+}
+`,
+		},
+		{
+			name: "RemoveAllTxn",
+			all:  true,
+			src: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	txn := newrelic.FromContext(ctx)
+	defer txn.StartSegment("hand_written").End()
+}
+
+func UseTxn(ctx context.Context) {
+	txn := newrelic.FromContext(ctx)
+	defer txn.StartSegment("hand_written").End()
+	txn.NoticeError(nil)
+}
+`,
+			want: `package main
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+}
+
+func UseTxn(ctx context.Context) {
+	txn := newrelic.FromContext(ctx)
+	txn.NoticeError(nil)
+}
+`,
+		},
+		{
+			name: "NoImport",
+			src: `package main
+
+import (
+	"context"
+)
+
+func SampleFunc(ctx context.Context) {
+}
+`,
+			want: `package main
+
+import (
+	"context"
+)
+
+func SampleFunc(ctx context.Context) {
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := Remove("", []byte(tt.src), tt.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+		})
+	}
+}
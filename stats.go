@@ -0,0 +1,231 @@
+package nrseg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html/template"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Output formats of stats subcommand. FormatText and FormatJSON are shared with inspect subcommand.
+const (
+	FormatHTML = "html"
+)
+
+// Statuses of the functions counted by stats subcommand.
+const (
+	// StatusInstrumented is the function which has the context and calls the segment.
+	StatusInstrumented = "instrumented"
+	// StatusMissing is the function which has the context but does not call the segment.
+	StatusMissing = "missing"
+	// StatusIgnored is the function which has the context but is ignored by the comment or skip_funcs.
+	StatusIgnored = "ignored"
+	// StatusSkipped is the function which has no context or no statements.
+	StatusSkipped = "skipped"
+)
+
+// funcStat is the status of the function/method.
+type funcStat struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+}
+
+// counts is the number of the functions for each status.
+// Eligible is the functions which should call the segment, Instrumented ones and missing ones.
+type counts struct {
+	Eligible     int     `json:"eligible"`
+	Instrumented int     `json:"instrumented"`
+	Ignored      int     `json:"ignored"`
+	Skipped      int     `json:"skipped"`
+	Coverage     float64 `json:"coverage"`
+}
+
+func (c *counts) add(status string) {
+	switch status {
+	case StatusInstrumented:
+		c.Eligible++
+		c.Instrumented++
+	case StatusMissing:
+		c.Eligible++
+	case StatusIgnored:
+		c.Ignored++
+	case StatusSkipped:
+		c.Skipped++
+	}
+	c.Coverage = 100
+	if c.Eligible != 0 {
+		c.Coverage = float64(c.Instrumented) * 100 / float64(c.Eligible)
+	}
+}
+
+type pkgStats struct {
+	Package string `json:"package"`
+	counts
+	Functions []funcStat `json:"functions"`
+}
+
+// stats is the instrumentation coverage per package and overall.
+type stats struct {
+	counts
+	Packages []*pkgStats `json:"packages"`
+}
+
+func validStatsFormat(format string) bool {
+	switch format {
+	case FormatText, FormatJSON, FormatHTML:
+		return true
+	}
+	return false
+}
+
+// stat counts the functions/methods in src. The function literals are not counted.
+func (n *nrseg) stat(r *fileResult, path string, src []byte) error {
+	if len(src) != 0 && c.Match(src) {
+		return nil
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, path, src, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	r.pkg = n.cfg.findingPackage(path, f.Name.Name)
+	r.funcs, err = statFile(fs, f, n.cfg)
+	return err
+}
+
+func statFile(fs *token.FileSet, f *ast.File, cfg *Config) ([]funcStat, error) {
+	if ast.IsGenerated(f) {
+		return nil, nil
+	}
+	ins := cfg.instrumenter()
+	pkg := ins.PackageName()
+	name, err := findImport(f, ins.ImportPath())
+	switch {
+	case errors.Is(err, ErrNoImportNrPkg):
+	case err != nil:
+		return nil, err
+	case len(name) != 0:
+		pkg = name
+	}
+
+	ignored := cfg.ignoredFile(fs, f)
+	ts := map[ast.Node]*funcTarget{}
+	for _, t := range findTargets(fs, f, cfg) {
+		ts[t.node] = t
+	}
+	params := cfg.directiveParams(fs, f, cfg.params(fs, f))
+	// the functions which are not changed since -since ref are not counted, they are neither skipped nor ignored.
+	changed := cfg.changedFunc(fs, f)
+	var result []funcStat
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Body == nil || !changed(fd.Body) {
+			continue
+		}
+		p := fs.Position(fd.Pos())
+		s := funcStat{Name: funcLabel(fd), File: p.Filename, Line: p.Line, Status: StatusSkipped}
+		if t, ok := ts[fd]; ok {
+			s.Status = StatusMissing
+			if ins.Instrumented(pkg, t.body.List[0]) {
+				s.Status = StatusInstrumented
+			}
+		} else if _, typ := params(fd, fd.Type); typ != TypeUnknown && (ignored || directivesOf(fs, f, fd).ignore || cfg.skipFunc(s.Name)) {
+			s.Status = StatusIgnored
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// newStats aggregates the functions per package.
+func newStats(funcs map[string][]funcStat) *stats {
+	st := &stats{Packages: []*pkgStats{}}
+	st.Coverage = 100
+	for pkg, fs := range funcs {
+		ps := &pkgStats{Package: pkg, Functions: fs}
+		ps.Coverage = 100
+		sort.Slice(fs, func(i, j int) bool {
+			if fs[i].File != fs[j].File {
+				return fs[i].File < fs[j].File
+			}
+			return fs[i].Line < fs[j].Line
+		})
+		for _, f := range fs {
+			ps.add(f.Status)
+			st.add(f.Status)
+		}
+		st.Packages = append(st.Packages, ps)
+	}
+	sort.Slice(st.Packages, func(i, j int) bool { return st.Packages[i].Package < st.Packages[j].Package })
+	return st
+}
+
+// writeStats writes the coverage, and sets errFlag if it is below -min-coverage.
+func (n *nrseg) writeStats() error {
+	st := newStats(n.funcs)
+	if err := writeStats(n.outStream, n.format, st); err != nil {
+		return err
+	}
+	if st.Coverage < n.minCoverage {
+		fmt.Fprintf(n.errStream, "coverage %.1f%% is below -min-coverage %.1f%%\n", st.Coverage, n.minCoverage)
+		n.errFlag = true
+	}
+	return nil
+}
+
+// writeStats writes st to w in format.
+func writeStats(w io.Writer, format string, st *stats) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	case FormatHTML:
+		return statsHTML.Execute(w, st)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tELIGIBLE\tINSTRUMENTED\tIGNORED\tSKIPPED\tCOVERAGE")
+	for _, p := range st.Packages {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f%%\n", p.Package, p.Eligible, p.Instrumented, p.Ignored, p.Skipped, p.Coverage)
+	}
+	fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f%%\n", "total", st.Eligible, st.Instrumented, st.Ignored, st.Skipped, st.Coverage)
+	return tw.Flush()
+}
+
+var statsHTML = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nrseg coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 2px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+summary { cursor: pointer; }
+.instrumented { color: #22863a; }
+.missing { color: #cb2431; }
+.ignored, .skipped { color: #6a737d; }
+</style>
+</head>
+<body>
+<h1>nrseg coverage {{printf "%.1f" .Coverage}}%</h1>
+<p>{{.Instrumented}} of {{.Eligible}} functions are instrumented. {{.Ignored}} ignored, {{.Skipped}} skipped.</p>
+{{range .Packages}}<details>
+<summary>{{.Package}}: {{printf "%.1f" .Coverage}}% ({{.Instrumented}}/{{.Eligible}})</summary>
+<table>
+<tr><th>function</th><th>file</th><th>status</th></tr>
+{{range .Functions}}<tr><td>{{.Name}}</td><td>{{.File}}:{{.Line}}</td><td class="{{.Status}}">{{.Status}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,138 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_statFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	src := `package stats
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Instrumented(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("instrumented").End()
+	_ = ctx
+}
+
+func Missing(ctx context.Context) {
+	_ = ctx
+}
+
+// nrseg:ignore it is too hot.
+func Ignored(ctx context.Context) {
+	_ = ctx
+}
+
+func Skipped() {
+	_ = 1
+}
+
+func Empty(ctx context.Context) {}
+
+type S struct{}
+
+func (S) SkipFunc(ctx context.Context) {
+	_ = ctx
+}
+`
+	path := filepath.Join(dir, "stats.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n := &nrseg{cfg: &Config{SkipFuncs: []string{`^S\.`}}}
+	if err := n.cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	r := &fileResult{}
+	if err := n.stat(r, path, []byte(src)); err != nil {
+		t.Fatal(err)
+	}
+	want := []funcStat{
+		{Name: "Instrumented", File: path, Line: 9, Status: StatusInstrumented},
+		{Name: "Missing", File: path, Line: 14, Status: StatusMissing},
+		{Name: "Ignored", File: path, Line: 19, Status: StatusIgnored},
+		{Name: "Skipped", File: path, Line: 23, Status: StatusSkipped},
+		{Name: "Empty", File: path, Line: 27, Status: StatusSkipped},
+		{Name: "S.SkipFunc", File: path, Line: 31, Status: StatusIgnored},
+	}
+	if diff := cmp.Diff(r.funcs, want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+	if r.pkg != "stats" {
+		t.Errorf("want package stats, but got %q", r.pkg)
+	}
+}
+
+func TestNrseg_Run_Stats(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name     string
+		args     []string
+		want     string
+		contains []string
+		wantErr  error
+	}{
+		{
+			name: "text",
+			args: []string{"nrseg", "stats", "-i", "ignore", "./testdata/input", "./testdata/want/ignore"},
+			want: `PACKAGE                                              ELIGIBLE  INSTRUMENTED  IGNORED  SKIPPED  COVERAGE
+github.com/budougumi0617/nrseg/testdata/input        5         1             1        3        20.0%
+github.com/budougumi0617/nrseg/testdata/want/ignore  3         0             0        0        0.0%
+total                                                8         1             1        3        12.5%
+`,
+		},
+		{
+			name: "min coverage",
+			args: []string{"nrseg", "stats", "-min-coverage", "50", "-format", "json", "./testdata/input/ignore"},
+			contains: []string{
+				`"coverage": 0,`,
+				`"name": "MustNotChange.SampleMethod",`,
+				`"status": "missing"`,
+			},
+			wantErr: ErrFlagTrue,
+		},
+		{
+			name: "html",
+			args: []string{"nrseg", "stats", "-min-coverage", "20", "-format", "html", "-i", "ignore", "./testdata/input"},
+			contains: []string{
+				"<h1>nrseg coverage 20.0%</h1>",
+				"<summary>github.com/budougumi0617/nrseg/testdata/input: 20.0% (1/5)</summary>",
+				`<td class="ignored">ignored</td>`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			out := &bytes.Buffer{}
+			errs := &bytes.Buffer{}
+			if err := Run(tt.args, out, errs, "", ""); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want %v, but got %v: %s", tt.wantErr, err, errs)
+			}
+			if len(tt.want) != 0 {
+				if diff := cmp.Diff(out.String(), tt.want); len(diff) != 0 {
+					t.Errorf("-got +want %v", diff)
+				}
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(out.String(), c) {
+					t.Errorf("want %q in\n%s", c, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package nrseg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Rename is the segment name rewritten by sync subcommand.
+type Rename struct {
+	File string
+	Line int
+	// Label is the human readable name of the function. ex: S.Method, SampleFunc.func1
+	Label string
+	Old   string
+	New   string
+}
+
+// Sync rewrites the segment names which do not match the function names.
+func Sync(filename string, src []byte) ([]byte, []Rename, error) {
+	return SyncWithConfig(filename, src, nil)
+}
+
+// SyncWithConfig rewrites the segment names which do not match the naming style of cfg.
+// Only the string literals of the names are rewritten, the other code is kept as it is.
+// cfg can be nil, then SyncWithConfig uses the default configuration.
+func SyncWithConfig(filename string, src []byte, cfg *Config) ([]byte, []Rename, error) {
+	if len(src) != 0 && c.Match(src) {
+		return src, nil, nil
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	is, err := Check(fs, f, cfg, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	var rs []Rename
+	for _, i := range is {
+		if i.Rule != RuleSegmentName {
+			continue
+		}
+		bl := findNameLit(i.Body.List[0], i.Current)
+		if bl == nil {
+			continue
+		}
+		edits = append(edits, edit{
+			start: fs.Position(bl.Pos()).Offset,
+			end:   fs.Position(bl.End()).Offset,
+			text:  strconv.Quote(i.Segment),
+		})
+		rs = append(rs, Rename{File: i.File, Line: fs.Position(bl.Pos()).Line, Label: i.Label, Old: i.Current, New: i.Segment})
+	}
+	if len(edits) == 0 {
+		return src, nil, nil
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var buf bytes.Buffer
+	var last int
+	for _, e := range edits {
+		buf.Write(src[last:e.start])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), rs, nil
+}
+
+// findNameLit returns the string literal of the segment name in s.
+func findNameLit(s ast.Stmt, name string) *ast.BasicLit {
+	var result *ast.BasicLit
+	ast.Inspect(s, func(n ast.Node) bool {
+		if bl, ok := n.(*ast.BasicLit); ok && bl.Kind == token.STRING {
+			if v, err := strconv.Unquote(bl.Value); err == nil && v == name {
+				result = bl
+			}
+		}
+		return result == nil
+	})
+	return result
+}
+
+// writeRenames writes the mapping from the old names to the new names.
+func writeRenames(w io.Writer, rs []Rename) {
+	for _, r := range rs {
+		fmt.Fprintf(w, "%s:%d: %s %q -> %q\n", r.File, r.Line, r.Label, r.Old, r.New)
+	}
+}
@@ -0,0 +1,231 @@
+package nrseg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSyncWithConfig(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name   string
+		cfg    *Config
+		src    string
+		want   string
+		wantRs []Rename
+	}{
+		{
+			name: "stale",
+			src: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type S struct{}
+
+func (s *S) Renamed(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("s_old_name").End()
+	_ = ctx
+}
+
+func Kept(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("kept").End()
+	_ = ctx
+}
+
+// the other code is not formatted.
+func  Other(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("old").End()
+	_ = ctx
+	go func(ctx context.Context) {
+		defer newrelic.FromContext(ctx).StartSegment("old_func").End()
+	}(ctx)
+}
+`,
+			want: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type S struct{}
+
+func (s *S) Renamed(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("s_renamed").End()
+	_ = ctx
+}
+
+func Kept(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("kept").End()
+	_ = ctx
+}
+
+// the other code is not formatted.
+func  Other(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("other").End()
+	_ = ctx
+	go func(ctx context.Context) {
+		defer newrelic.FromContext(ctx).StartSegment("other_func1").End()
+	}(ctx)
+}
+`,
+			wantRs: []Rename{
+				{File: "sample.go", Line: 12, Label: "S.Renamed", Old: "s_old_name", New: "s_renamed"},
+				{File: "sample.go", Line: 23, Label: "Other", Old: "old", New: "other"},
+				{File: "sample.go", Line: 26, Label: "Other.func1", Old: "old_func", New: "other_func1"},
+			},
+		},
+		{
+			name: "naming",
+			cfg:  &Config{Naming: "camel"},
+			src: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("sample_func").End()
+}
+`,
+			want: `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("sampleFunc").End()
+}
+`,
+			wantRs: []Rename{
+				{File: "sample.go", Line: 10, Label: "SampleFunc", Old: "sample_func", New: "sampleFunc"},
+			},
+		},
+		{
+			name: "otel",
+			cfg:  &Config{Backend: BackendOpenTelemetry},
+			src: `package sample
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer("example.com/sample").Start(ctx, "old")
+	defer span.End()
+	_ = ctx
+}
+`,
+			want: `package sample
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+func SampleFunc(ctx context.Context) {
+	ctx, span := otel.Tracer("example.com/sample").Start(ctx, "sample_func")
+	defer span.End()
+	_ = ctx
+}
+`,
+			wantRs: []Rename{
+				{File: "sample.go", Line: 10, Label: "SampleFunc", Old: "old", New: "sample_func"},
+			},
+		},
+		{
+			name: "not instrumented",
+			src: `package sample
+
+import "context"
+
+func SampleFunc(ctx context.Context) {
+	_ = ctx
+}
+`,
+			want: `package sample
+
+import "context"
+
+func SampleFunc(ctx context.Context) {
+	_ = ctx
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if tt.cfg != nil {
+				if err := tt.cfg.Compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, rs, err := SyncWithConfig("sample.go", []byte(tt.src), tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(got), tt.want); len(diff) != 0 {
+				t.Errorf("-got +want %v", diff)
+			}
+			if diff := cmp.Diff(rs, tt.wantRs); len(diff) != 0 {
+				t.Errorf("renames -got +want %v", diff)
+			}
+		})
+	}
+}
+
+func TestNrseg_Run_Sync(t *testing.T) {
+	t.Parallel()
+	dir := configDir(t)
+	path := filepath.Join(dir, "sample.go")
+	src := `package sample
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func SampleFunc(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("old_name").End()
+}
+`
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	if err := Run([]string{"nrseg", "sync", dir}, out, errs, "", ""); err != nil {
+		t.Fatalf("Run() error %v: %s", err, errs)
+	}
+	want := path + `:10: SampleFunc "old_name" -> "sample_func"` + "\n"
+	if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte(`StartSegment("sample_func")`)) {
+		t.Errorf("the segment name is not rewritten:\n%s", got)
+	}
+}
@@ -0,0 +1,328 @@
+package nrseg
+
+import (
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// funcTarget is a function/method or a function literal which can be inserted a segment.
+type funcTarget struct {
+	node ast.Node // *ast.FuncDecl or *ast.FuncLit
+	body *ast.BlockStmt
+	// label is the human readable name used by reports. ex: S.Method, SampleFunc.func1
+	label string
+	// name is the segment name.
+	name string
+	nd   *NameData
+	// vn is the variable name which has context.Context or *http.Request.
+	vn  string
+	typ string
+	// ctx is the expression which returns context.Context from vn.
+	ctx string
+	obj *ast.Object
+	// captured is true if the variable is captured from the enclosing function.
+	captured bool
+	// line is the line of the function.
+	line int
+	// notice is true if the error result is noticed.
+	notice bool
+}
+
+// findTargets returns all functions and function literals in f which have context.Context or *http.Request.
+// The function literal can use the variable captured from the enclosing function.
+func findTargets(fs *token.FileSet, f *ast.File, cfg *Config) []*funcTarget {
+	changed := cfg.changedFunc(fs, f)
+	_, nr := cfg.instrumenter().(newrelicInstrumenter)
+	var result []*funcTarget
+	for _, t := range findFuncs(fs, f, cfg) {
+		if t.typ == TypeUnknown || len(t.body.List) == 0 || !changed(t.body) {
+			continue
+		}
+		ds := directivesOf(fs, f, t.node)
+		if !ds.force && cfg.skipFunc(t.label) {
+			continue
+		}
+		t.name = cfg.segName(t.nd)
+		if len(ds.name) != 0 {
+			t.name = ds.name
+		}
+		t.ctx = cfg.ctxExpr(t.typ, t.vn)
+		t.line = fs.Position(t.node.Pos()).Line
+		t.notice = nr && (cfg.noticeErrors() || ds.notice) && noticeable(funcTypeOf(t.node))
+		result = append(result, t)
+	}
+	return result
+}
+
+// findFuncs returns all functions and function literals in f which are not ignored.
+// Their parameters are detected, but they can have neither context.Context nor *http.Request.
+func findFuncs(fs *token.FileSet, f *ast.File, cfg *Config) []*funcTarget {
+	if cfg.ignoredFile(fs, f) {
+		return nil
+	}
+	fn := fs.File(f.Pos()).Name()
+	base := &NameData{
+		Package:    f.Name.Name,
+		ImportPath: cfg.importPath(fn),
+		File:       filepath.Base(fn),
+		modulePath: cfg.modulePath(fn),
+	}
+	params := cfg.directiveParams(fs, f, cfg.params(fs, f))
+	var ts []*funcTarget
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Body == nil || directivesOf(fs, f, d).ignore {
+				continue
+			}
+			t := newTarget(params, d, d.Type, d.Body, nil)
+			t.label = funcLabel(d)
+			nd := *base
+			nd.Receiver, nd.Function = recvName(d), d.Name.Name
+			if len(nd.Receiver) != 0 {
+				nd.parts = append(nd.parts, nd.Receiver)
+			}
+			nd.parts = append(nd.parts, nd.Function)
+			t.nd = &nd
+			ts = append(ts, t)
+			ts = append(ts, findLitTargets(fs, f, params, d.Body, t)...)
+		case *ast.GenDecl:
+			ts = append(ts, findLitTargets(fs, f, params, d, &funcTarget{nd: base})...)
+		}
+	}
+	return ts
+}
+
+// paramsFunc returns the variable name and the type of the parameter which has the context.
+type paramsFunc func(n ast.Node, ft *ast.FuncType) (string, string)
+
+func newTarget(params paramsFunc, n ast.Node, ft *ast.FuncType, body *ast.BlockStmt, outer *funcTarget) *funcTarget {
+	t := &funcTarget{node: n, body: body}
+	t.vn, t.typ = params(n, ft)
+	if t.typ != TypeUnknown {
+		t.obj = paramObj(ft, t.vn)
+		return t
+	}
+	if outer != nil && outer.obj != nil && refer(body, outer.obj) {
+		t.vn, t.typ, t.obj = outer.vn, outer.typ, outer.obj
+		t.captured = true
+	}
+	return t
+}
+
+// findLitTargets returns the function literals in root.
+// outer is the enclosing function of root, its label is empty if root is not a function.
+func findLitTargets(fs *token.FileSet, f *ast.File, params paramsFunc, root ast.Node, outer *funcTarget) []*funcTarget {
+	var ts []*funcTarget
+	var cnt int
+	var stack []ast.Node
+	// outers is the stack of the functions which have the variables can be captured.
+	outers := []*funcTarget{outer}
+	parents := []*funcTarget{outer}
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == nil {
+			if _, ok := stack[len(stack)-1].(*ast.FuncLit); ok {
+				outers = outers[:len(outers)-1]
+				parents = parents[:len(parents)-1]
+			}
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		fl, ok := n.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		cnt++
+		o, p := outers[len(outers)-1], parents[len(parents)-1]
+		t := newTarget(params, fl, fl.Type, fl.Body, o)
+		sfx, route := litSuffix(stack, cnt)
+		t.label, t.nd = sfx, p.nd.child(sfx, route)
+		if len(p.label) != 0 {
+			t.label = p.label + "." + sfx
+		}
+		parents = append(parents, t)
+		if t.typ == TypeUnknown {
+			// nested function literals can capture the variable of the outer function.
+			outers = append(outers, o)
+			return true
+		}
+		outers = append(outers, t)
+		if ds := directivesOf(fs, f, fl); !ds.ignore && (ds.force || !isDeferred(stack)) {
+			ts = append(ts, t)
+		}
+		return true
+	})
+	return ts
+}
+
+var nonAlnum = regexp.MustCompile("[^0-9A-Za-z]+")
+
+// litSuffix returns the name of the function literal which is the last node of stack.
+// It uses the variable name or the route pattern if exists.
+func litSuffix(stack []ast.Node, cnt int) (string, string) {
+	def := "func" + strconv.Itoa(cnt)
+	if len(stack) < 2 {
+		return def, ""
+	}
+	fl := stack[len(stack)-1]
+	switch p := stack[len(stack)-2].(type) {
+	case *ast.AssignStmt:
+		for i, r := range p.Rhs {
+			if r == fl && i < len(p.Lhs) {
+				if n := exprName(p.Lhs[i]); len(n) != 0 && n != "_" {
+					return n, ""
+				}
+			}
+		}
+	case *ast.ValueSpec:
+		for i, v := range p.Values {
+			if v == fl && i < len(p.Names) && p.Names[i].Name != "_" {
+				return p.Names[i].Name, ""
+			}
+		}
+	case *ast.KeyValueExpr:
+		if n := exprName(p.Key); len(n) != 0 {
+			return n, ""
+		}
+	case *ast.CallExpr:
+		if len(p.Args) < 2 || p.Args[0] == fl {
+			return def, ""
+		}
+		bl, ok := p.Args[0].(*ast.BasicLit)
+		if !ok || bl.Kind != token.STRING {
+			return def, ""
+		}
+		r, err := strconv.Unquote(bl.Value)
+		if err != nil {
+			return def, ""
+		}
+		if sfx := strings.Trim(nonAlnum.ReplaceAllString(r, "_"), "_"); len(sfx) != 0 {
+			return sfx, r
+		}
+		return def, r
+	}
+	return def, ""
+}
+
+func exprName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return ""
+}
+
+// isDeferred reports whether the last node of stack is a deferred function literal.
+// ex:
+//
+//	defer func() { ... }()
+func isDeferred(stack []ast.Node) bool {
+	if len(stack) < 3 {
+		return false
+	}
+	ce, ok := stack[len(stack)-2].(*ast.CallExpr)
+	if !ok || ce.Fun != stack[len(stack)-1] {
+		return false
+	}
+	_, ok = stack[len(stack)-3].(*ast.DeferStmt)
+	return ok
+}
+
+func paramObj(ft *ast.FuncType, name string) *ast.Object {
+	for _, f := range ft.Params.List {
+		for _, n := range f.Names {
+			if n.Name == name {
+				return n.Obj
+			}
+		}
+	}
+	return nil
+}
+
+// refer reports whether n uses obj.
+func refer(n ast.Node, obj *ast.Object) bool {
+	var result bool
+	ast.Inspect(n, func(n ast.Node) bool {
+		if idt, ok := n.(*ast.Ident); ok && idt.Obj == obj {
+			result = true
+		}
+		return !result
+	})
+	return result
+}
+
+func recvName(fd *ast.FuncDecl) string {
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		if rn, ok := fd.Recv.List[0].Type.(*ast.StarExpr); ok {
+			if idt, ok := rn.X.(*ast.Ident); ok {
+				return idt.Name
+			}
+		} else if idt, ok := fd.Recv.List[0].Type.(*ast.Ident); ok {
+			return idt.Name
+		}
+	}
+	return ""
+}
+
+func funcLabel(fd *ast.FuncDecl) string {
+	if rcv := recvName(fd); len(rcv) != 0 {
+		return rcv + "." + fd.Name.Name
+	}
+	return fd.Name.Name
+}
+
+// freeName returns the variable name which does not change the meaning of the function if it is declared at the top of body.
+// The name must not be declared at the top level of the function, and must not refer to the outer variable.
+// If name is used, alt, alt2, alt3... are tried in order.
+func freeName(ft *ast.FuncType, body *ast.BlockStmt, name, alt string) string {
+	top := map[ast.Node]bool{}
+	for _, s := range body.List {
+		switch s := s.(type) {
+		case *ast.AssignStmt:
+			top[s] = true
+		case *ast.DeclStmt:
+			if gd, ok := s.Decl.(*ast.GenDecl); ok {
+				for _, sp := range gd.Specs {
+					top[sp] = true
+				}
+			}
+		}
+	}
+	used := func(name string) bool {
+		var result bool
+		check := func(n ast.Node) bool {
+			idt, ok := n.(*ast.Ident)
+			if !ok || idt.Name != name {
+				return !result
+			}
+			// the variable which is declared in the nested block can shadow the name.
+			if idt.Obj == nil {
+				result = true
+				return false
+			}
+			d, ok := idt.Obj.Decl.(ast.Node)
+			if !ok || top[d] || d.Pos() < body.Lbrace || body.Rbrace < d.Pos() {
+				result = true
+			}
+			return !result
+		}
+		ast.Inspect(ft, check)
+		ast.Inspect(body, check)
+		return result
+	}
+	if !used(name) {
+		return name
+	}
+	result := alt
+	for i := 2; used(result); i++ {
+		result = alt + strconv.Itoa(i)
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// TemplateData is the data of the statement template.
+type TemplateData struct {
+	// Pkg is the package name of the import path in the file.
+	Pkg string
+	// Ctx is the expression which returns context.Context. ex: ctx, req.Context()
+	Ctx string
+	// Name is the quoted segment name. ex: "sample_func"
+	Name string
+}
+
+const (
+	ctxPlaceholder  = "nrsegCtxPlaceholder"
+	namePlaceholder = "nrsegNamePlaceholder"
+)
+
+type templateInstrumenter struct {
+	tmpl       *template.Template
+	importPath string
+	pkgName    string
+	// matchers caches the regular expressions of the statements for each package name.
+	matchers sync.Map
+}
+
+// NewTemplateInstrumenter returns the Instrumenter which inserts the statements built by the Go template.
+// stmt is the statements with TemplateData. ex: defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()
+func NewTemplateInstrumenter(stmt, importPath string) (Instrumenter, error) {
+	if len(importPath) == 0 {
+		return nil, errors.New("import path of the template is empty")
+	}
+	tmpl, err := template.New("statement").Parse(stmt)
+	if err != nil {
+		return nil, err
+	}
+	ti := &templateInstrumenter{
+		tmpl:       tmpl,
+		importPath: importPath,
+		pkgName:    defaultPkgName(importPath),
+	}
+	if _, err := ti.matcher(ti.pkgName); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+// defaultPkgName returns the last element of ip which is not the major version.
+// ex: github.com/foo/tracing/v2 -> tracing
+func defaultPkgName(ip string) string {
+	n := path.Base(ip)
+	if dir := path.Dir(ip); dir != "." && len(n) > 1 && n[0] == 'v' {
+		if _, err := strconv.Atoi(n[1:]); err == nil {
+			n = path.Base(dir)
+		}
+	}
+	return strings.ReplaceAll(n, "-", "_")
+}
+
+func (ti *templateInstrumenter) ImportPath() string { return ti.importPath }
+
+func (ti *templateInstrumenter) PackageName() string { return ti.pkgName }
+
+func (ti *templateInstrumenter) Build(t *Target) []ast.Stmt {
+	ss, err := ti.parse(TemplateData{Pkg: t.Pkg, Ctx: t.Ctx, Name: strconv.Quote(t.Name)})
+	if err != nil {
+		// the template was validated by NewTemplateInstrumenter.
+		return nil
+	}
+	for _, s := range ss {
+		setPos(s, t.Pos)
+	}
+	return ss
+}
+
+func (ti *templateInstrumenter) Instrumented(pkg string, s ast.Stmt) bool {
+	ms, err := ti.matcher(pkg)
+	if err != nil {
+		return false
+	}
+	return ms[0].MatchString(printStmt(s))
+}
+
+func (ti *templateInstrumenter) SegmentName(pkg string, s ast.Stmt) (string, bool) {
+	ms, err := ti.matcher(pkg)
+	if err != nil {
+		return "", false
+	}
+	for _, m := range ms {
+		sm := m.FindStringSubmatch(printStmt(s))
+		if sm == nil {
+			continue
+		}
+		if i := m.SubexpIndex("name"); i > 0 {
+			if n, err := strconv.Unquote(sm[i]); err == nil {
+				return n, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (ti *templateInstrumenter) Generated(pkg string, ss []ast.Stmt, _ bool) int {
+	ms, err := ti.matcher(pkg)
+	if err != nil || len(ss) < len(ms) {
+		return 0
+	}
+	for i, m := range ms {
+		if !m.MatchString(printStmt(ss[i])) {
+			return 0
+		}
+	}
+	return len(ms)
+}
+
+func (ti *templateInstrumenter) parse(d TemplateData) ([]ast.Stmt, error) {
+	var buf bytes.Buffer
+	if err := ti.tmpl.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	src := "package p\nfunc _() {\n" + buf.String() + "\n}"
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the statement template: %w", err)
+	}
+	ss := f.Decls[0].(*ast.FuncDecl).Body.List
+	if len(ss) == 0 {
+		return nil, errors.New("the statement template is empty")
+	}
+	return ss, nil
+}
+
+// matcher returns the regular expressions which match the statements of the template.
+func (ti *templateInstrumenter) matcher(pkg string) ([]*regexp.Regexp, error) {
+	if v, ok := ti.matchers.Load(pkg); ok {
+		return v.([]*regexp.Regexp), nil
+	}
+	ss, err := ti.parse(TemplateData{Pkg: pkg, Ctx: ctxPlaceholder, Name: namePlaceholder})
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*regexp.Regexp, 0, len(ss))
+	for _, s := range ss {
+		expr := regexp.QuoteMeta(printStmt(s))
+		expr = strings.ReplaceAll(expr, ctxPlaceholder, `.+?`)
+		expr = strings.Replace(expr, namePlaceholder, `(?P<name>"(?:[^"\\]|\\.)*"|`+"`[^`]*`"+`)`, 1)
+		expr = strings.ReplaceAll(expr, namePlaceholder, `(?:"(?:[^"\\]|\\.)*"|`+"`[^`]*`"+`)`)
+		m, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	ti.matchers.Store(pkg, ms)
+	return ms, nil
+}
+
+func printStmt(s ast.Stmt) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), s); err != nil {
+		return ""
+	}
+	return buf.String()
+}
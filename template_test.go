@@ -0,0 +1,200 @@
+package nrseg
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const templateSrc = `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+func SampleFunc(ctx context.Context) {
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+`
+
+func TestNewTemplateInstrumenter(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, stmt, path string
+		wantPkg          string
+		wantErr          bool
+	}{
+		{name: "Basic", stmt: "defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()", path: "github.com/foo/tracing", wantPkg: "tracing"},
+		{name: "MajorVersion", stmt: "defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()", path: "github.com/foo/tracing/v2", wantPkg: "tracing"},
+		{name: "NoImport", stmt: "defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()", wantErr: true},
+		{name: "BadTemplate", stmt: "defer {{.Pkg}.Segment()", path: "github.com/foo/tracing", wantErr: true},
+		{name: "UnknownField", stmt: "defer {{.Package}}.Segment()", path: "github.com/foo/tracing", wantErr: true},
+		{name: "NotStatement", stmt: "func {{.Pkg}}", path: "github.com/foo/tracing", wantErr: true},
+		{name: "Empty", stmt: "", path: "github.com/foo/tracing", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := NewTemplateInstrumenter(tt.stmt, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTemplateInstrumenter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.PackageName() != tt.wantPkg {
+				t.Errorf("PackageName() = %q, want %q", got.PackageName(), tt.wantPkg)
+			}
+		})
+	}
+}
+
+func TestProcessWithConfig_Template(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{
+		Template:       "sp := {{.Pkg}}.Start({{.Ctx}}, {{.Name}})\ndefer sp.End()",
+		TemplateImport: "github.com/foo/tracing",
+		ImportAlias:    "tr",
+	}
+	if err := cfg.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	tr "github.com/foo/tracing"
+)
+
+func SampleFunc(ctx context.Context) {
+	sp := tr.Start(ctx, "sample_func")
+	defer sp.End()
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	sp := tr.Start(req.Context(), "sample_handler")
+	defer sp.End()
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+`
+	got, err := ProcessWithConfig("", []byte(templateSrc), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	// already instrumented.
+	again, err := ProcessWithConfig("", got, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(again), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	removed, err := RemoveWithConfig("", got, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(removed), templateSrc); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+}
+
+func TestTemplateInstrumenter_SegmentName(t *testing.T) {
+	t.Parallel()
+	ins, err := NewTemplateInstrumenter("defer {{.Pkg}}.Segment({{.Ctx}}, {{.Name}})()", "github.com/foo/tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := ins.(*templateInstrumenter).parse(TemplateData{Pkg: "tracing", Ctx: "r.Context()", Name: `"sample"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := ins.(SegmentNamer).SegmentName("tracing", ss[0])
+	if !ok || got != "sample" {
+		t.Errorf("SegmentName() = %q, %v, want %q", got, ok, "sample")
+	}
+	if ins.Instrumented("tr", ss[0]) {
+		t.Errorf("Instrumented() = true with the other package name")
+	}
+}
+
+// stubInstrumenter inserts the call of the function which is not a package.
+// ex: trace(ctx, "name")
+type stubInstrumenter struct{}
+
+func (stubInstrumenter) ImportPath() string { return "github.com/foo/stub" }
+
+func (stubInstrumenter) PackageName() string { return "stub" }
+
+func (stubInstrumenter) Build(t *Target) []ast.Stmt {
+	return []ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{
+		Fun:    &ast.SelectorExpr{X: ast.NewIdent(t.Pkg), Sel: ast.NewIdent("Trace")},
+		Lparen: t.Pos,
+		Args:   []ast.Expr{t.CtxExpr(), &ast.BasicLit{ValuePos: t.Pos, Kind: token.STRING, Value: strconv.Quote(t.Name)}},
+		Rparen: t.Pos,
+	}}}
+}
+
+func (stubInstrumenter) Instrumented(pkg string, s ast.Stmt) bool {
+	es, ok := s.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	ce, ok := es.X.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	se, ok := ce.Fun.(*ast.SelectorExpr)
+	return ok && se.Sel.Name == "Trace"
+}
+
+func TestProcessWithConfig_Instrumenter(t *testing.T) {
+	t.Parallel()
+	cfg := &Config{Instrumenter: stubInstrumenter{}}
+	want := `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/foo/stub"
+)
+
+func SampleFunc(ctx context.Context) {
+	stub.Trace(ctx, "sample_func")
+	fmt.Println("Hello, playground")
+}
+
+func SampleHandler(w http.ResponseWriter, req *http.Request) {
+	stub.Trace(req.Context(), "sample_handler")
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+`
+	got, err := ProcessWithConfig("", []byte(templateSrc), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); len(diff) != 0 {
+		t.Errorf("-got +want %v", diff)
+	}
+
+	if _, err := RemoveWithConfig("", got, cfg, false); err == nil {
+		t.Error("RemoveWithConfig() want error because stubInstrumenter does not implement Remover")
+	}
+}
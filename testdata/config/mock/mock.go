@@ -0,0 +1,10 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+)
+
+func Ignored(ctx context.Context) {
+	fmt.Println("skip by ignore")
+}
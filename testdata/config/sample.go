@@ -0,0 +1,21 @@
+package config
+
+import (
+	"context"
+	"fmt"
+)
+
+type Client struct{}
+
+func NewClient(ctx context.Context) *Client {
+	fmt.Println("skip by skip_funcs")
+	return &Client{}
+}
+
+func (c *Client) FindByID(ctx context.Context, id int) {
+	fmt.Println("Hello, playground")
+}
+
+func SampleFunc(ctx context.Context) {
+	fmt.Println("Hello, playground")
+}
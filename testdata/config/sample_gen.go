@@ -0,0 +1,10 @@
+package config
+
+import (
+	"context"
+	"fmt"
+)
+
+func Excluded(ctx context.Context) {
+	fmt.Println("skip by exclude")
+}
@@ -0,0 +1,42 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var sampleHandler = func(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+
+func NewMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello, %q", r.URL.Path)
+	})
+	return mux
+}
+
+func SampleGroup(ctx context.Context) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		fmt.Println(ctx)
+		return nil
+	})
+	eg.Go(func() error {
+		fmt.Println("not capture")
+		return nil
+	})
+	// nrseg:ignore this is test.
+	eg.Go(func() error {
+		fmt.Println(ctx)
+		return nil
+	})
+	defer func() {
+		fmt.Println(ctx)
+	}()
+	return eg.Wait()
+}
@@ -2,4 +2,7 @@ module github.com/budougumi0617/nrseg/testdata/input
 
 go 1.15
 
-require github.com/newrelic/go-agent/v3 v3.9.0
+require (
+	github.com/newrelic/go-agent/v3 v3.9.0
+	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+)
@@ -0,0 +1,16 @@
+package broken
+
+import (
+	"context"
+	"fmt"
+)
+
+type Ctx = context.Context
+
+func Alias(c Ctx) {
+	fmt.Println("type alias is not detected without type information")
+}
+
+func SampleFunc(ctx context.Context) {
+	fmt.Println(undefined)
+}
@@ -0,0 +1,10 @@
+package typed
+
+import (
+	. "context"
+	"fmt"
+)
+
+func Dot(ctx Context) {
+	fmt.Println("dot import")
+}
@@ -0,0 +1,7 @@
+module example.com/typed
+
+go 1.23
+
+require google.golang.org/grpc v0.0.0
+
+replace google.golang.org/grpc => ./grpc
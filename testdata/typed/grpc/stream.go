@@ -0,0 +1,10 @@
+// Package grpc is the stub of google.golang.org/grpc for the type-checked tests.
+package grpc
+
+import "context"
+
+type ServerStream interface {
+	Context() context.Context
+	SendMsg(m any) error
+	RecvMsg(m any) error
+}
@@ -0,0 +1,20 @@
+package typed
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+type Event struct{}
+
+// Watcher_WatchServer is the server stream generated by protoc-gen-go-grpc.
+type Watcher_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+func (s *S) Watch(n int, stream Watcher_WatchServer) error {
+	fmt.Println("generated stream interface")
+	return nil
+}
@@ -0,0 +1,47 @@
+package typed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type Ctx = context.Context
+
+type UserContext interface {
+	context.Context
+	UserID() string
+}
+
+type EmbedContext struct {
+	context.Context
+}
+
+type S struct{}
+
+func Alias(c Ctx) {
+	fmt.Println("type alias")
+}
+
+func (s *S) Interface(uc UserContext) {
+	fmt.Println("interface embeds context.Context")
+}
+
+func Embed(n int, ec EmbedContext) {
+	fmt.Println("struct embeds context.Context")
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("*http.Request")
+}
+
+func Closure() {
+	f := func(ctx UserContext) {
+		fmt.Println("function literal")
+	}
+	f(nil)
+}
+
+func NoContext(s string) {
+	fmt.Println("no context")
+}
@@ -0,0 +1,47 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"golang.org/x/sync/errgroup"
+)
+
+var sampleHandler = func(w http.ResponseWriter, req *http.Request) {
+	defer newrelic.FromContext(req.Context()).StartSegment("sample_handler").End()
+	fmt.Fprintf(w, "Hello, %q", req.URL.Path)
+}
+
+func NewMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		defer newrelic.FromContext(r.Context()).StartSegment("new_mux_users").End()
+		fmt.Fprintf(w, "Hello, %q", r.URL.Path)
+	})
+	return mux
+}
+
+func SampleGroup(ctx context.Context) error {
+	defer newrelic.FromContext(ctx).StartSegment("sample_group").End()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		defer newrelic.FromContext(ctx).StartSegment("sample_group_func1").End()
+		fmt.Println(ctx)
+		return nil
+	})
+	eg.Go(func() error {
+		fmt.Println("not capture")
+		return nil
+	})
+	// nrseg:ignore this is test.
+	eg.Go(func() error {
+		fmt.Println(ctx)
+		return nil
+	})
+	defer func() {
+		fmt.Println(ctx)
+	}()
+	return eg.Wait()
+}
@@ -0,0 +1,318 @@
+package nrseg
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	gotypes "go/types"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// typedParam is the parameter detected with the type information.
+type typedParam struct {
+	name, typ string
+}
+
+// typedFile is the type information of the type-checked file.
+type typedFile struct {
+	// params is keyed by the offset of the function.
+	params map[int]typedParam
+	// muxes has the offsets of the receivers of Handle and HandleFunc which are net/http or *http.ServeMux.
+	muxes map[int]bool
+}
+
+// typeIndex has the type-checked files keyed by the absolute file path.
+type typeIndex map[string]typedFile
+
+// LoadTypes type-checks the packages of patterns in dir and uses the type information to detect the parameters.
+// The pattern is "./..." if patterns is empty. It can be called for each module.
+// The parameter whose type implements context.Context or is *http.Request is detected.
+// The files of the packages which do not type-check are processed by the syntactic detection.
+func (cfg *Config) LoadTypes(dir string, patterns ...string) error {
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	idx, err := loadTypes(dir, patterns, cfg.contextTypes())
+	if err != nil {
+		return err
+	}
+	if cfg.typed == nil {
+		cfg.typed = typeIndex{}
+	}
+	for fn, tf := range idx {
+		cfg.typed[fn] = tf
+	}
+	return nil
+}
+
+func loadTypes(dir string, patterns []string, cts []*contextType) (typeIndex, error) {
+	pc := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports,
+		Dir: dir,
+	}
+	// context is loaded together to get context.Context which shares the types with the packages.
+	pkgs, err := packages.Load(pc, append(patterns, "context")...)
+	if err != nil {
+		return nil, err
+	}
+	var tps []*gotypes.Package
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		if p.Types != nil {
+			tps = append(tps, p.Types)
+		}
+	})
+	ctx := contextInterface(tps)
+	if ctx == nil {
+		return nil, errors.New("cannot load context.Context")
+	}
+	ifaces := contextInterfaces(tps, cts)
+
+	idx := typeIndex{}
+	for _, p := range pkgs {
+		if p.PkgPath == "context" || len(p.Errors) != 0 || p.TypesInfo == nil {
+			continue
+		}
+		for _, f := range p.Syntax {
+			fn, err := filepath.Abs(p.Fset.File(f.Pos()).Name())
+			if err != nil {
+				return nil, err
+			}
+			idx[fn] = newTypedFile(p.Fset, f, p.TypesInfo, ctx, cts, ifaces)
+		}
+	}
+	return idx, nil
+}
+
+// LoadTypesInfo uses the type information of the files which the caller type-checked to detect the parameters.
+// It is used by the drivers which type-check the packages by themselves. ex: the go/analysis passes
+// pkg is the package of files, context.Context is searched from it and its imports.
+func (cfg *Config) LoadTypesInfo(fs *token.FileSet, pkg *gotypes.Package, files []*ast.File, info *gotypes.Info) error {
+	tps := importedPackages(pkg)
+	ctx := contextInterface(tps)
+	if ctx == nil {
+		return errors.New("cannot find context.Context in the imports")
+	}
+	ifaces := contextInterfaces(tps, cfg.contextTypes())
+	if cfg.typed == nil {
+		cfg.typed = typeIndex{}
+	}
+	for _, f := range files {
+		fn, err := filepath.Abs(fs.File(f.Pos()).Name())
+		if err != nil {
+			return err
+		}
+		cfg.typed[fn] = newTypedFile(fs, f, info, ctx, cfg.contextTypes(), ifaces)
+	}
+	return nil
+}
+
+// importedPackages returns pkg and the packages which it imports directly or indirectly.
+func importedPackages(pkg *gotypes.Package) []*gotypes.Package {
+	seen := map[*gotypes.Package]bool{}
+	var result []*gotypes.Package
+	var visit func(p *gotypes.Package)
+	visit = func(p *gotypes.Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		result = append(result, p)
+		for _, i := range p.Imports() {
+			visit(i)
+		}
+	}
+	visit(pkg)
+	return result
+}
+
+// contextInterface returns context.Context in pkgs.
+func contextInterface(pkgs []*gotypes.Package) *gotypes.Interface {
+	for _, p := range pkgs {
+		if p.Path() != "context" {
+			continue
+		}
+		if o := p.Scope().Lookup("Context"); o != nil {
+			ctx, _ := o.Type().Underlying().(*gotypes.Interface)
+			return ctx
+		}
+	}
+	return nil
+}
+
+// newTypedFile returns the type information of the parameters and the receivers of the handler registrations in f.
+func newTypedFile(fs *token.FileSet, f *ast.File, info *gotypes.Info, ctx *gotypes.Interface, cts []*contextType, ifaces map[*contextType]*gotypes.Interface) typedFile {
+	tf := typedFile{params: map[int]typedParam{}, muxes: map[int]bool{}}
+	ast.Inspect(f, func(n ast.Node) bool {
+		var ft *ast.FuncType
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			ft = n.Type
+		case *ast.FuncLit:
+			ft = n.Type
+		case *ast.SelectorExpr:
+			if (n.Sel.Name == "Handle" || n.Sel.Name == "HandleFunc") && isServeMux(info, n.X) {
+				tf.muxes[fs.Position(n.X.Pos()).Offset] = true
+			}
+			return true
+		default:
+			return true
+		}
+		if tp, ok := typedParams(info, ctx, cts, ifaces, ft); ok {
+			tf.params[fs.Position(n.Pos()).Offset] = tp
+		}
+		return true
+	})
+	return tf
+}
+
+// contextInterfaces returns the interfaces of the context types which are found in pkgs.
+// ex: grpc.ServerStream
+func contextInterfaces(pkgs []*gotypes.Package, cts []*contextType) map[*contextType]*gotypes.Interface {
+	ifaces := map[*contextType]*gotypes.Interface{}
+	for _, p := range pkgs {
+		for _, ct := range cts {
+			if ct.ImportPath != p.Path() || strings.HasPrefix(ct.Type, "*") {
+				continue
+			}
+			if o, ok := p.Scope().Lookup(ct.Type).(*gotypes.TypeName); ok {
+				if it, ok := o.Type().Underlying().(*gotypes.Interface); ok {
+					ifaces[ct] = it
+				}
+			}
+		}
+	}
+	return ifaces
+}
+
+// typedParams returns the first parameter which implements context.Context.
+// If there is no such parameter, it returns the parameter of the framework type or *http.Request.
+// The parameter whose type implements the interface of the framework is the framework type too.
+// ex: the server stream interface generated by protoc-gen-go-grpc implements grpc.ServerStream.
+func typedParams(info *gotypes.Info, ctx *gotypes.Interface, cts []*contextType, ifaces map[*contextType]*gotypes.Interface, ft *ast.FuncType) (typedParam, bool) {
+	var fw, req *typedParam
+	for _, f := range ft.Params.List {
+		for _, n := range f.Names {
+			o := info.Defs[n]
+			if o == nil || n.Name == "_" {
+				continue
+			}
+			if ct := matchContextType(cts, ifaces, o.Type()); ct != nil {
+				if fw == nil {
+					fw = &typedParam{name: n.Name, typ: ct.key()}
+				}
+				continue
+			}
+			if gotypes.Implements(o.Type(), ctx) {
+				return typedParam{name: n.Name, typ: TypeContext}, true
+			}
+			if req == nil && isHTTPRequest(o.Type()) {
+				req = &typedParam{name: n.Name, typ: TypeHttpRequest}
+			}
+		}
+	}
+	if fw != nil {
+		return *fw, true
+	}
+	if req != nil {
+		return *req, true
+	}
+	return typedParam{}, false
+}
+
+func matchContextType(cts []*contextType, ifaces map[*contextType]*gotypes.Interface, t gotypes.Type) *contextType {
+	for _, ct := range cts {
+		if ct.matchType(t) {
+			return ct
+		}
+	}
+	for _, ct := range cts {
+		if it, ok := ifaces[ct]; ok && gotypes.Implements(t, it) {
+			return ct
+		}
+	}
+	return nil
+}
+
+func isHTTPRequest(t gotypes.Type) bool {
+	p, ok := t.(*gotypes.Pointer)
+	if !ok {
+		return false
+	}
+	n, ok := p.Elem().(*gotypes.Named)
+	if !ok {
+		return false
+	}
+	o := n.Obj()
+	return o.Pkg() != nil && o.Pkg().Path() == "net/http" && o.Name() == "Request"
+}
+
+// isServeMux reports whether e is the net/http package, the value of *http.ServeMux
+// or the type *http.ServeMux of the method expression.
+func isServeMux(info *gotypes.Info, e ast.Expr) bool {
+	if idt, ok := e.(*ast.Ident); ok {
+		if pn, ok := info.Uses[idt].(*gotypes.PkgName); ok {
+			return pn.Imported().Path() == "net/http"
+		}
+	}
+	tv, ok := info.Types[e]
+	if !ok {
+		return false
+	}
+	t := tv.Type
+	p, ptr := t.(*gotypes.Pointer)
+	switch {
+	case !tv.IsValue() && !tv.IsType():
+		return false
+	case ptr:
+		t = p.Elem()
+	case tv.IsType() || !tv.Addressable():
+		return false
+	}
+	n, ok := t.(*gotypes.Named)
+	if !ok {
+		return false
+	}
+	o := n.Obj()
+	return o.Pkg() != nil && o.Pkg().Path() == "net/http" && o.Name() == "ServeMux"
+}
+
+// params returns the function which detects the parameter of the function in the file.
+func (cfg *Config) params(fs *token.FileSet, f *ast.File) paramsFunc {
+	syntactic := cfg.syntacticParams(f)
+	if cfg == nil || cfg.typed == nil {
+		return syntactic
+	}
+	fn, err := filepath.Abs(fs.File(f.Pos()).Name())
+	if err != nil {
+		return syntactic
+	}
+	tf, ok := cfg.typed[fn]
+	if !ok {
+		return syntactic
+	}
+	return func(n ast.Node, _ *ast.FuncType) (string, string) {
+		if tp, ok := tf.params[fs.Position(n.Pos()).Offset]; ok {
+			return tp.name, tp.typ
+		}
+		return "", TypeUnknown
+	}
+}
+
+// syntacticParams returns the function which detects the parameter by the type expression.
+func (cfg *Config) syntacticParams(f *ast.File) paramsFunc {
+	cts := cfg.contextTypes()
+	return func(_ ast.Node, ft *ast.FuncType) (string, string) {
+		vn, typ := parseParams(f.Imports, ft)
+		if typ == TypeContext {
+			return vn, typ
+		}
+		if n, t := parseContextTypes(f.Imports, ft, cts); t != TypeUnknown {
+			return n, t
+		}
+		return vn, typ
+	}
+}
@@ -0,0 +1,202 @@
+package nrseg
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// file is the Go file to process.
+type file struct {
+	// root is the directory of the argument which has path. It is the base of -destination.
+	root string
+	path string
+	// dir is true if the file is found by walking its directory, so the other files of the package are processed too.
+	dir bool
+}
+
+// stdin is read by -files-from -.
+var stdin io.Reader = os.Stdin
+
+// targets returns the arguments and the paths listed by -files-from.
+// The current directory is the target if there are neither.
+func (n *nrseg) targets() (args, listed []string, err error) {
+	args = n.args
+	if len(n.filesFrom) != 0 {
+		if listed, err = readPaths(n.filesFrom); err != nil {
+			return nil, nil, err
+		}
+	} else if len(args) == 0 {
+		args = []string{n.in}
+	}
+	return args, listed, nil
+}
+
+// readPaths reads the paths from name, one per line. "-" is stdin.
+func readPaths(name string) ([]string, error) {
+	r := stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var ps []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if p := strings.TrimSpace(sc.Text()); len(p) != 0 {
+			ps = append(ps, p)
+		}
+	}
+	return ps, sc.Err()
+}
+
+// walk calls fn with the files to process in args and listed.
+// args are directories, Go files or the patterns with "..." like the go tool.
+// The listed files are skipped if they are in the ignored directories, but the files in args are not.
+// It calls fail with the path which cannot be walked, and continues walking the others.
+func (n *nrseg) walk(args, listed []string, fn func(f file), fail func(path string, err error)) {
+	seen := map[string]bool{}
+	emit := func(f file) {
+		if p := filepath.Clean(f.path); !seen[p] {
+			seen[p] = true
+			fn(f)
+		}
+	}
+	for _, a := range args {
+		n.walkArg(a, false, emit, fail)
+	}
+	for _, a := range listed {
+		n.walkArg(a, true, emit, fail)
+	}
+}
+
+func (n *nrseg) walkArg(arg string, listed bool, fn func(f file), fail func(path string, err error)) {
+	if strings.Contains(arg, "...") {
+		n.walkDir(baseDir(arg), matchPattern(arg), fn, fail)
+		return
+	}
+	fi, err := os.Stat(arg)
+	if err != nil {
+		fail(arg, err)
+		return
+	}
+	if fi.IsDir() {
+		n.walkDir(arg, nil, fn, fail)
+		return
+	}
+	if n.isTarget(arg) && !(listed && n.inIgnoredDir(arg)) {
+		fn(file{root: filepath.Dir(arg), path: arg})
+	}
+}
+
+// walkDir calls fn with the files in root recursively.
+// If match is not nil, the files are in the directories which match the pattern.
+func (n *nrseg) walkDir(root string, match func(dir string) bool, fn func(f file), fail func(path string, err error)) {
+	// the callback never returns errors except SkipDir.
+	_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fail(path, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if path == root {
+				// the root is walked even if it is ignored, because it is the explicit target.
+				return nil
+			}
+			if n.skipDir(path) {
+				return filepath.SkipDir
+			}
+			// the go tool ignores them with "...".
+			if b := filepath.Base(path); match != nil && (strings.HasPrefix(b, ".") || strings.HasPrefix(b, "_") || b == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if match != nil && !match(filepath.Dir(path)) {
+			return nil
+		}
+		if n.isTarget(path) {
+			fn(file{root: root, path: path, dir: true})
+		}
+		return nil
+	})
+}
+
+// isTarget reports whether path is the Go file to process.
+func (n *nrseg) isTarget(path string) bool {
+	if filepath.Ext(path) != ".go" {
+		return false
+	}
+	if strings.HasSuffix(filepath.Base(path), "_test.go") {
+		return false
+	}
+	return n.cfg.matchFile(path) && n.cfg.changedFile(path)
+}
+
+// inIgnoredDir reports whether any directory of path is ignored.
+func (n *nrseg) inIgnoredDir(path string) bool {
+	for d := filepath.Dir(filepath.Clean(path)); d != filepath.Dir(d); d = filepath.Dir(d) {
+		if n.skipDir(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseDir returns the directory which the argument walks.
+// ex: ./internal/... -> internal, ./cmd/.../api -> cmd, ./foo.go -> .
+func baseDir(arg string) string {
+	if i := strings.Index(arg, "..."); i >= 0 {
+		// "x" is the placeholder of the element which has "...".
+		return filepath.Dir(arg[:i] + "x")
+	}
+	if fi, err := os.Stat(arg); err == nil && !fi.IsDir() {
+		return filepath.Dir(arg)
+	}
+	return arg
+}
+
+// matchPattern returns the function which reports whether the directory matches the pattern.
+// "..." matches any string, and "foo/..." matches "foo" too like the go tool.
+func matchPattern(pattern string) func(dir string) bool {
+	re := regexp.QuoteMeta(filepath.ToSlash(filepath.Clean(pattern)))
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	r := regexp.MustCompile("^" + re + "$")
+	return func(dir string) bool {
+		return r.MatchString(filepath.ToSlash(filepath.Clean(dir)))
+	}
+}
+
+// typeRoot is the packages loaded by LoadTypes.
+type typeRoot struct {
+	dir, pattern string
+}
+
+// typeRoots returns the packages which have the targets.
+func typeRoots(ts []string) []typeRoot {
+	var rs []typeRoot
+	seen := map[typeRoot]bool{}
+	for _, t := range ts {
+		r := typeRoot{dir: baseDir(t), pattern: "./..."}
+		if fi, err := os.Stat(t); err == nil && !fi.IsDir() {
+			r.pattern = "."
+		}
+		if !seen[r] {
+			seen[r] = true
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
@@ -0,0 +1,108 @@
+package nrseg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_matchPattern(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		pattern, dir string
+		want         bool
+	}{
+		{pattern: "./...", dir: ".", want: true},
+		{pattern: "./...", dir: "foo/bar", want: true},
+		{pattern: "./internal/...", dir: "internal", want: true},
+		{pattern: "./internal/...", dir: "internal/foo", want: true},
+		{pattern: "./internal/...", dir: "internalx", want: false},
+		{pattern: "./cmd/.../api", dir: "cmd/foo/api", want: true},
+		{pattern: "./cmd/.../api", dir: "cmd/foo/api/v1", want: false},
+		{pattern: "foo...", dir: "foobar", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern)(tt.dir); got != tt.want {
+			t.Errorf("matchPattern(%q)(%q) = %v, want %v", tt.pattern, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func Test_baseDir(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		arg, want string
+	}{
+		{arg: "./...", want: "."},
+		{arg: "./internal/...", want: "internal"},
+		{arg: "./cmd/.../api", want: "cmd"},
+		{arg: "./testdata/input", want: "./testdata/input"},
+		{arg: "./testdata/input/basic.go", want: "testdata/input"},
+	}
+	for _, tt := range tests {
+		if got := baseDir(tt.arg); got != tt.want {
+			t.Errorf("baseDir(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestNrseg_Run_FilesFrom(t *testing.T) {
+	dir := configDir(t)
+	src := []byte("package main\n\nimport \"context\"\n\nfunc SampleFunc(ctx context.Context) {\n\t_ = ctx\n}\n")
+	for _, p := range []string{"a.go", "mock/b.go", "c_test.go"} {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := strings.Join([]string{
+		filepath.Join(dir, "a.go"),
+		"",
+		filepath.Join(dir, "mock", "b.go"),
+		filepath.Join(dir, "c_test.go"),
+		filepath.Join(dir, "README.md"),
+	}, "\n")
+	want := filepath.Join(dir, "a.go") + ":5:1: SampleFunc no insert segment\n"
+
+	t.Run("file", func(t *testing.T) {
+		lp := filepath.Join(t.TempDir(), "list.txt")
+		if err := os.WriteFile(lp, []byte(list), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		errs := &bytes.Buffer{}
+		args := []string{"nrseg", "inspect", "-i", "mock", "-files-from", lp}
+		// README.md is not a Go file, but it does not exist.
+		err := Run(args, out, errs, "", "")
+		var fes FileErrors
+		if !errors.As(err, &fes) || len(fes) != 1 || !strings.HasSuffix(fes[0].Path, "README.md") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+			t.Errorf("-got +want %v", diff)
+		}
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		org := stdin
+		defer func() { stdin = org }()
+		stdin = strings.NewReader(filepath.Join(dir, "a.go") + "\n" + filepath.Join(dir, "mock", "b.go") + "\n")
+		out := &bytes.Buffer{}
+		errs := &bytes.Buffer{}
+		args := []string{"nrseg", "inspect", "-i", "mock", "-files-from", "-"}
+		if err := Run(args, out, errs, "", ""); !errors.Is(err, ErrFlagTrue) {
+			t.Fatalf("want %v, but got %v", ErrFlagTrue, err)
+		}
+		if diff := cmp.Diff(out.String(), want); len(diff) != 0 {
+			t.Errorf("-got +want %v", diff)
+		}
+	})
+}
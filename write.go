@@ -0,0 +1,56 @@
+package nrseg
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFile replaces path with data atomically through the temporary file in the same directory.
+// The mode of path is preserved if it exists, otherwise perm is used.
+// If backup is not empty, the original file is kept at path+backup.
+func writeFile(path string, data []byte, perm os.FileMode, backup string) (err error) {
+	// rename replaces the symbolic link itself.
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case err == nil:
+		perm = fi.Mode().Perm()
+		if len(backup) != 0 {
+			org, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := writeFile(path+backup, org, perm, ""); err != nil {
+				return err
+			}
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".nrseg*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
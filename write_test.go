@@ -0,0 +1,86 @@
+package nrseg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_writeFile(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		name, backup string
+		perm         os.FileMode
+	}{
+		{name: "preserveMode", perm: 0600},
+		{name: "backup", backup: ".orig", perm: 0640},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			p := filepath.Join(dir, "a.go")
+			org := []byte("package a\n\n// long original content\n")
+			if err := os.WriteFile(p, org, tt.perm); err != nil {
+				t.Fatal(err)
+			}
+			// the umask can change the mode of the new file.
+			if err := os.Chmod(p, tt.perm); err != nil {
+				t.Fatal(err)
+			}
+			want := []byte("package a\n")
+			if err := writeFile(p, want, 0644, tt.backup); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			fi, err := os.Stat(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != tt.perm {
+				t.Errorf("mode = %v, want %v", fi.Mode().Perm(), tt.perm)
+			}
+
+			es, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantFiles := 1
+			if len(tt.backup) != 0 {
+				wantFiles = 2
+				bk, err := os.ReadFile(p + tt.backup)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(bk, org) {
+					t.Errorf("backup = %q, want %q", bk, org)
+				}
+			}
+			if len(es) != wantFiles {
+				t.Errorf("temporary files are left: %v", es)
+			}
+		})
+	}
+}
+
+func TestNrseg_Run_NestedDestination(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "nested", "dest")
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	args := []string{"nrseg", "-destination", dest, "./testdata/input"}
+	if err := Run(args, out, errs, "", ""); err != nil {
+		t.Fatalf("Run() error = %v, %s", err, errs.String())
+	}
+	if _, err := os.Stat(filepath.Join(dest, "ignore", "must_not_change.go")); err != nil {
+		t.Errorf("nested destination is not created: %v", err)
+	}
+}